@@ -0,0 +1,243 @@
+package md2txt
+
+import "bytes"
+
+// autolink parses the autolink beginning src,which is <scheme:path> or <email>,
+// n is the length including the angle brackets,and is 0 if src does not begin with an autolink.
+// see https://spec.commonmark.org/0.31.2/#autolinks
+func autolink(src []byte) (url, text []byte, n int) {
+	if len(src) < 3 || src[0] != '<' {
+		return nil, nil, 0
+	}
+	if n = uri(src[1:]); n > 0 && n+1 < len(src) && src[n+1] == '>' {
+		return src[1 : n+1], src[1 : n+1], n + 2
+	}
+	if n = email(src[1:]); n > 0 && n+1 < len(src) && src[n+1] == '>' {
+		return append([]byte("mailto:"), src[1:n+1]...), src[1 : n+1], n + 2
+	}
+	return nil, nil, 0
+}
+
+// uri returns the length of the absolute uri beginning src,
+// which is a scheme,':' and characters other than controls,spaces,'<' and '>',
+// the scheme is a letter followed by 1-31 letters,digits,'+','.' or '-'.
+func uri(src []byte) int {
+	if len(src) == 0 || !isLetter(src[0]) {
+		return 0
+	}
+	n := 1
+	for n < len(src) && n <= 32 && (isAlnum(src[n]) || src[n] == '+' || src[n] == '.' || src[n] == '-') {
+		n++
+	}
+	if n < 2 || n > 32 || n == len(src) || src[n] != ':' {
+		return 0
+	}
+	n++
+	for n < len(src) && src[n] > ' ' && src[n] != '<' && src[n] != '>' {
+		n++
+	}
+	return n
+}
+
+// email returns the length of the email address beginning src,
+// which is the local part,'@' and labels separated by periods,
+// a label is 1-63 letters,digits and '-',and does not begin or end with '-'.
+func email(src []byte) int {
+	n := 0
+	for n < len(src) && (isAlnum(src[n]) || bytes.IndexByte([]byte(".!#$%&'*+/=?^_`{|}~-"), src[n]) != -1) {
+		n++
+	}
+	if n == 0 || n == len(src) || src[n] != '@' {
+		return 0
+	}
+	for {
+		// skip '@' or '.'.
+		n++
+		m := 0
+		for n+m < len(src) && (isAlnum(src[n+m]) || src[n+m] == '-') {
+			m++
+		}
+		if m == 0 || m > 63 || src[n] == '-' || src[n+m-1] == '-' {
+			return 0
+		}
+		n += m
+		if n == len(src) || src[n] != '.' {
+			return n
+		}
+	}
+}
+
+// extendedAutolink parses the www.,http(s):// or ftp:// link or email beginning src,
+// n is the length of the link without trailing punctuation,
+// and is 0 if src does not begin with a link.
+// see https://github.github.com/gfm/#autolinks-extension-
+func extendedAutolink(src []byte) (url []byte, n int) {
+	if prefix := extPrefix(src); prefix > 0 {
+		start := prefix
+		if src[0] == 'w' {
+			// www. is a part of the domain.
+			start = 0
+		}
+		d := domain(src[start:], src[0] != 'w')
+		if d == 0 {
+			return nil, 0
+		}
+		n = start + d
+		for n < len(src) && src[n] > ' ' && src[n] != '<' {
+			n++
+		}
+		n = trimLink(src[:n])
+		if n <= prefix {
+			return nil, 0
+		}
+		if src[0] == 'w' {
+			return append([]byte("http://"), src[:n]...), n
+		}
+		return src[:n], n
+	}
+	if n = extEmail(src); n > 0 {
+		// only '.' can end the email,which is not a part of it.
+		if c := src[n-1]; c == '-' || c == '_' {
+			return nil, 0
+		}
+		return append([]byte("mailto:"), src[:n]...), n
+	}
+	return nil, 0
+}
+
+// extPrefix returns the length of www.,http://,https:// or ftp:// beginning src,
+// returns 0 if there is none.
+func extPrefix(src []byte) int {
+	for _, prefix := range []string{"www.", "http://", "https://", "ftp://"} {
+		if bytes.HasPrefix(src, []byte(prefix)) {
+			return len(prefix)
+		}
+	}
+	return 0
+}
+
+// extEmail returns the length of the email beginning src,
+// which is letters,digits and ._+- followed by '@' and at least two segments
+// of letters,digits,'_' and '-' separated by periods.
+func extEmail(src []byte) int {
+	n := 0
+	for n < len(src) && (isAlnum(src[n]) || bytes.IndexByte([]byte("._+-"), src[n]) != -1) {
+		n++
+	}
+	if n == 0 || n == len(src) || src[n] != '@' {
+		return 0
+	}
+	segments := 0
+	for i := n + 1; ; i++ {
+		// the segment begins src[i:].
+		m := 0
+		for i+m < len(src) && (isAlnum(src[i+m]) || src[i+m] == '_' || src[i+m] == '-') {
+			m++
+		}
+		if m == 0 {
+			break
+		}
+		segments++
+		n, i = i+m, i+m
+		if i == len(src) || src[i] != '.' {
+			break
+		}
+	}
+	if segments < 2 {
+		return 0
+	}
+	return n
+}
+
+// domain returns the length of the valid domain beginning src,
+// which is segments of alphanumerics,'_' and '-' separated by periods,
+// the last two segments can't contain '_',
+// and there is at least one period unless short.
+func domain(src []byte, short bool) int {
+	var (
+		n, periods int
+		last, cur  bool // underscores in the last two segments.
+	)
+loop:
+	for ; n < len(src); n++ {
+		switch c := src[n]; {
+		case c == '_':
+			cur = true
+		case c == '.':
+			last, cur = cur, false
+			periods++
+		case c == '-' || c >= 0x80 || isAlnum(c):
+		default:
+			break loop
+		}
+	}
+	if n == 0 || last || cur || !short && periods == 0 {
+		return 0
+	}
+	return n
+}
+
+// trimLink returns the length of link without trailing punctuation,
+// which is one of ?!.,:*_~,')' not matching '(' or an entity reference.
+func trimLink(link []byte) int {
+	n := len(link)
+	for n > 0 {
+		switch link[n-1] {
+		case '?', '!', '.', ',', ':', '*', '_', '~':
+			n--
+		case ')':
+			if bytes.Count(link[:n], []byte{')'}) <= bytes.Count(link[:n], []byte{'('}) {
+				return n
+			}
+			n--
+		case ';':
+			// an entity reference is &name;.
+			i := n - 2
+			for i >= 0 && isAlnum(link[i]) {
+				i--
+			}
+			if i < 0 || i == n-2 || link[i] != '&' {
+				return n
+			}
+			n = i
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// wordStart returns true if extended autolinks can begin src[i:],
+// which is at the start,after whitespace or one of *_~(.
+func wordStart(src []byte, i int) bool {
+	return i == 0 || bytes.IndexByte([]byte(" \t\n*_~("), src[i-1]) != -1
+}
+
+// autolinkAt returns the length of the autolink beginning src[i:],
+// extended autolinks are recognised if the document enables them.
+func (d *document) autolinkAt(src []byte, i int) int {
+	if src[i] == '<' {
+		_, _, n := autolink(src[i:])
+		return n
+	}
+	if d.has(ExtAutolink) && wordStart(src, i) {
+		_, n := extendedAutolink(src[i:])
+		return n
+	}
+	return 0
+}
+
+// parseAutolink parses autolinks,
+// which are links whose text is the url.
+func parseAutolink(p *spanParser) spanStateFn {
+	url, text, n := autolink(p.src[p.cur:])
+	if n == 0 {
+		url, n = extendedAutolink(p.src[p.cur:])
+		text = p.src[p.cur : p.cur+n]
+	}
+	// copy as the source is cut below.
+	url, text = clone(url), clone(text)
+	p.src = append(p.src[:p.cur], p.src[p.cur+n:]...)
+	p.emit(&Link{start: p.cur, text: text, url: url, auto: true, doc: p.doc})
+	return parseSpan
+}
@@ -0,0 +1,198 @@
+/*
+Command md2txt converts markdown to pure text.
+
+Usage:
+
+	md2txt [-commonmark] [-gfm] [-crlf] [-softbreak preserve|space|smart] [-table aligned|cells]
+		[-ext highlight,sup,sub,...] [-style strikethrough=drop,...] [file]
+	md2txt tangle [-d dir] file...
+
+With no file,md2txt reads the standard input.
+
+The -ext flag enables the extensions autolink,table,strikethrough,highlight,sup and sub,
+-gfm enables the first three.
+The -style flag renders the text of those spans as text,drop or marked,
+marked keeps the delimiters,e.g. -style strikethrough=drop,sup=marked.
+
+The tangle subcommand writes the code blocks whose info string names a file,
+e.g. "```go file=main.go",into that file under dir,
+blocks naming the same file are concatenated in document order.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zouhuigang/md2txt"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "tangle" {
+		tangle(os.Args[2:])
+		return
+	}
+	convert(os.Args[1:])
+}
+
+// convert writes the text of markdown file to the standard output.
+func convert(args []string) {
+	fs := flag.NewFlagSet("md2txt", flag.ExitOnError)
+	commonMark := fs.Bool("commonmark", false, "parse in CommonMark instead of basic markdown")
+	gfm := fs.Bool("gfm", false, "parse in Github Flavored Markdown")
+	crlf := fs.Bool("crlf", false, "end lines of the text with CRLF")
+	softBreak := fs.String("softbreak", "preserve", "join lines of paragraphs: preserve,space or smart")
+	table := fs.String("table", "aligned", "lay out tables: aligned,or cells for one cell per line")
+	exts := fs.String("ext", "", "comma separated extensions to enable")
+	styles := fs.String("style", "", "comma separated span=style,style is text,drop or marked")
+	fs.Parse(args)
+
+	src, err := read(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	ext := md2txt.BASIC
+	if *commonMark {
+		ext = md2txt.CommonMark
+	}
+	if *gfm {
+		ext = md2txt.GFM
+	}
+	for _, name := range split(*exts) {
+		e, ok := extensions[name]
+		if !ok {
+			fatal(fmt.Errorf("unknown extension %q", name))
+		}
+		ext |= e
+	}
+	opts := md2txt.DefaultOptions(ext)
+	for _, s := range split(*styles) {
+		name, value, _ := strings.Cut(s, "=")
+		style, ok := spanStyles[value]
+		if !ok {
+			fatal(fmt.Errorf("unknown style %q", s))
+		}
+		switch name {
+		case "strikethrough":
+			opts.Strikethrough = style
+		case "highlight":
+			opts.Highlight = style
+		case "sup":
+			opts.Superscript = style
+		case "sub":
+			opts.Subscript = style
+		default:
+			fatal(fmt.Errorf("unknown span %q", name))
+		}
+	}
+	switch *softBreak {
+	case "preserve":
+	case "space":
+		opts.SoftBreak = md2txt.SoftBreakSpace
+	case "smart":
+		opts.SoftBreak = md2txt.SoftBreakSmart
+	default:
+		fatal(fmt.Errorf("unknown soft break %q", *softBreak))
+	}
+	switch *table {
+	case "aligned":
+	case "cells":
+		opts.TableStyle = md2txt.TableCellPerLine
+	default:
+		fatal(fmt.Errorf("unknown table layout %q", *table))
+	}
+	eol := []byte{'\n'}
+	if *crlf {
+		opts.LineEnding = md2txt.CRLF
+		eol = []byte("\r\n")
+	}
+	os.Stdout.Write(md2txt.ParseWithOptions(src, opts))
+	os.Stdout.Write(eol)
+}
+
+// tangle writes the code blocks naming a file into that file.
+func tangle(args []string) {
+	fs := flag.NewFlagSet("md2txt tangle", flag.ExitOnError)
+	dir := fs.String("d", ".", "directory the files are written to")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fatal(fmt.Errorf("usage: md2txt tangle [-d dir] file..."))
+	}
+
+	var (
+		names []string
+		files = make(map[string][]byte)
+	)
+	for _, arg := range fs.Args() {
+		src, err := read(arg)
+		if err != nil {
+			fatal(err)
+		}
+		for _, s := range md2txt.ExtractCode(src) {
+			name := s.File()
+			if name == "" {
+				continue
+			}
+			// keep files under dir.
+			name = filepath.Clean(filepath.FromSlash(name))
+			if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+				fatal(fmt.Errorf("%s:%d: file %q is outside the directory", arg, s.Pos, s.File()))
+			}
+			if _, ok := files[name]; !ok {
+				names = append(names, name)
+			}
+			files[name] = append(append(files[name], s.Text...), '\n')
+		}
+	}
+	for _, name := range names {
+		path := filepath.Join(*dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			fatal(err)
+		}
+		if err := os.WriteFile(path, files[name], 0644); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+var (
+	// extensions by the names of -ext.
+	extensions = map[string]md2txt.EXT{
+		"autolink":      md2txt.ExtAutolink,
+		"table":         md2txt.ExtTable,
+		"strikethrough": md2txt.ExtStrikethrough,
+		"highlight":     md2txt.ExtHighlight,
+		"sup":           md2txt.ExtSuperscript,
+		"sub":           md2txt.ExtSubscript,
+	}
+	spanStyles = map[string]md2txt.SpanStyle{
+		"text":   md2txt.SpanText,
+		"drop":   md2txt.SpanDrop,
+		"marked": md2txt.SpanMarked,
+	}
+)
+
+// split returns the comma separated values of s.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// read returns the content of file,or the standard input if file is "".
+func read(file string) ([]byte, error) {
+	if file == "" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "md2txt:", err)
+	os.Exit(1)
+}
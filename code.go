@@ -0,0 +1,77 @@
+package md2txt
+
+import (
+	"strings"
+)
+
+// CodeSnippet is a code block extracted from markdown.
+type CodeSnippet struct {
+	Lang string // language of fenced code,e.g. go for "```go".
+	Info string // info string following the opening fence.
+	Text string // code without the indentation or fences.
+	Pos  int    // line number the code block begins at.
+}
+
+// File returns the file named by the info string,
+// e.g. main.go for "```go file=main.go",
+// returns "" if there is none.
+func (s CodeSnippet) File() string {
+	info := s.Info
+	for {
+		i := strings.Index(info, "file=")
+		if i == -1 {
+			return ""
+		}
+		// file= must begin a word.
+		if i > 0 && info[i-1] != ' ' && info[i-1] != '\t' {
+			info = info[i+5:]
+			continue
+		}
+		name := info[i+5:]
+		if strings.HasPrefix(name, `"`) {
+			if j := strings.IndexByte(name[1:], '"'); j != -1 {
+				return name[1 : j+1]
+			}
+		}
+		if j := strings.IndexAny(name, " \t"); j != -1 {
+			name = name[:j]
+		}
+		return name
+	}
+}
+
+// ExtractCode returns code blocks of src in document order,
+// including those nested in quotes and lists.
+// src is parsed in CommonMark.
+func ExtractCode(src []byte) []CodeSnippet {
+	p := (&document{opts: DefaultOptions(CommonMark)}).newParser(normalize(src), 1)
+	var snippets []CodeSnippet
+	for b := p.element(); b != nil; b = p.element() {
+		snippets = appendCode(snippets, b)
+	}
+	return snippets
+}
+
+// appendCode appends code blocks in b to snippets.
+func appendCode(snippets []CodeSnippet, b Block) []CodeSnippet {
+	switch b := b.(type) {
+	case *CodeBlock:
+		snippets = append(snippets, CodeSnippet{
+			Lang: b.Lang(),
+			Info: b.Info(),
+			Text: string(b.Content()),
+			Pos:  b.line,
+		})
+	case *QuoteBlock:
+		for _, v := range b.subBlocks {
+			snippets = appendCode(snippets, v)
+		}
+	case *List:
+		for _, item := range b.items {
+			for _, v := range item.subBlocks {
+				snippets = appendCode(snippets, v)
+			}
+		}
+	}
+	return snippets
+}
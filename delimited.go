@@ -0,0 +1,48 @@
+package md2txt
+
+// delimited returns the kind of span enclosed in the run of c with length n,
+// ok is false if no enabled extension makes such a span.
+// a single '~' is subscript if enabled,or strikethrough of GFM.
+func (d *document) delimited(c byte, n int) (ext EXT, ok bool) {
+	switch {
+	case c == '~' && n == 2 && d.has(ExtStrikethrough):
+		return ExtStrikethrough, true
+	case c == '~' && n == 1 && d.has(ExtSubscript):
+		return ExtSubscript, true
+	case c == '~' && n == 1 && d.has(ExtStrikethrough):
+		return ExtStrikethrough, true
+	case c == '=' && n == 2 && d.has(ExtHighlight):
+		return ExtHighlight, true
+	case c == '^' && n == 1 && d.has(ExtSuperscript):
+		return ExtSuperscript, true
+	}
+	return 0, false
+}
+
+// delimitedSpan returns the span enclosed in delim at start,
+// which is strikethrough ~~text~~,highlight ==text==,superscript ^text^ or subscript ~text~,
+// delim is matched with its closer by parseEmphasis,
+// superscript and subscript can't contain spaces,e.g. 2^10^ and H~2~O.
+func (d *document) delimitedSpan(start int, delim, content []byte) Span {
+	ext, _ := d.delimited(delim[0], len(delim))
+	switch ext {
+	case ExtHighlight:
+		return &Highlight{start, delim, content, d}
+	case ExtSuperscript:
+		return &Superscript{start, delim, content, d}
+	case ExtSubscript:
+		return &Subscript{start, delim, content, d}
+	}
+	return &Strikethrough{start, delim, content, d}
+}
+
+// styled returns the text of a span enclosed in delim as style requires.
+func (d *document) styled(style SpanStyle, delim, content []byte) []byte {
+	switch style {
+	case SpanDrop:
+		return nil
+	case SpanMarked:
+		return append(append(append([]byte{}, delim...), d.spanText(content)...), delim...)
+	}
+	return d.spanText(content)
+}
@@ -0,0 +1,262 @@
+package md2txt
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// delimiter is a run of '*','_' or the delimiters of the extensions which can open or close spans.
+// see https://spec.commonmark.org/0.31.2/#emphasis-and-strong-emphasis
+type delimiter struct {
+	pos        int  // index of the run in the source.
+	length     int  // length of the run.
+	char       byte // '*','_','~','=' or '^'.
+	space      int  // index of the last whitespace before the run,-1 if none.
+	nospace    bool // the span can't contain whitespace,as superscript and subscript.
+	canOpen    bool
+	canClose   bool
+	open       int // delimiters left to open,which are used from the right.
+	closed     int // delimiters used to close,which are used from the left.
+	prev, next int // indexes of the neighbours in the stack,-1 if none.
+}
+
+// match is emphasis made of an opener and a closer.
+type match struct {
+	from, to int // indexes of the used delimiters of the opener and the closer.
+	n        int // number of delimiters used,2 for strong.
+}
+
+// parseEmphasis parses emphasis,strong emphasis or the spans of delimitedSpan beginning with the delimiter run,
+// the delimiters of the rest of the source are matched once as the delimiter stack of CommonMark,
+// and the content is parsed as spans again,so that emphasis nests.
+// delimiters which are not matched are kept as text.
+func parseEmphasis(p *spanParser) spanStateFn {
+	if p.matches == nil {
+		p.matches = make(map[int]match)
+		for _, m := range processEmphasis(p.delimiters(p.cur)) {
+			p.matches[m.from] = m
+		}
+		p.matched = len(p.src)
+	}
+	// the source is cut before the current index only,
+	// which shifts the indexes of the matches by the same offset.
+	offset := p.matched - len(p.src)
+	c := p.src[p.cur]
+	run := len(p.src[p.cur:]) - len(trimLeft(p.src[p.cur:], c))
+	// the first used delimiter of the run opens the outermost emphasis,
+	// and the delimiters before it are text.
+	for i := p.cur; i < p.cur+run; i++ {
+		m, ok := p.matches[i+offset]
+		if !ok {
+			continue
+		}
+		p.cur = i
+		to := m.to - offset
+		content := clone(p.src[i+m.n : to])
+		delim := clone(p.src[i : i+m.n])
+		p.src = append(p.src[:i], p.src[to+m.n:]...)
+		switch {
+		case c != '*' && c != '_':
+			p.emit(p.doc.delimitedSpan(p.cur, delim, content))
+		case m.n == 2:
+			p.emit(&Strong{p.cur, content, p.doc})
+		default:
+			p.emit(&Emphasis{p.cur, content, p.doc})
+		}
+		return parseSpan
+	}
+	p.cur += run
+	p.ignore()
+	return parseSpan
+}
+
+// delimiters returns the delimiter runs of src[i:],
+// the run at i is the first one,
+// runs of '~','=' and '^' are delimiters only if they make a span of an enabled extension.
+func (p *spanParser) delimiters(i int) []*delimiter {
+	var delims []*delimiter
+	space := -1
+	for src := p.src; i < len(src); {
+		if n := p.unit(i); n > 0 {
+			if k := bytes.LastIndexFunc(src[i:i+n], unicode.IsSpace); k != -1 {
+				space = i + k
+			}
+			i += n
+			continue
+		}
+		switch c := src[i]; c {
+		case '*', '_', '~', '=', '^':
+			n := len(src[i:]) - len(trimLeft(src[i:], c))
+			d := &delimiter{pos: i, length: n, char: c, space: space, open: n}
+			if c != '*' && c != '_' {
+				ext, ok := p.doc.delimited(c, n)
+				if !ok {
+					i += n
+					continue
+				}
+				d.nospace = ext == ExtSuperscript || ext == ExtSubscript
+			}
+			d.canOpen, d.canClose = flanking(src, i, n)
+			delims = append(delims, d)
+			i += n
+		default:
+			r, size := utf8.DecodeRune(src[i:])
+			if unicode.IsSpace(r) {
+				space = i
+			}
+			i += size
+		}
+	}
+	return delims
+}
+
+// unit returns the length of the escape,code span,autolink,raw html or link beginning src[i:],
+// which is skipped as a whole when delimiters are looked for,
+// returns 0 if there is none.
+func (p *spanParser) unit(i int) int {
+	src := p.src
+	switch c := src[i]; {
+	case c == '\\':
+		// a backslash at the end escapes nothing.
+		if i+1 == len(src) {
+			return 1
+		}
+		return 2
+	case c == '`':
+		if n := codeSpan(src[i:]); n > 0 {
+			return n
+		}
+		// backticks not closed can't close code spans either.
+		return len(src[i:]) - len(trimLeft(src[i:], '`'))
+	case p.doc.autolinkAt(src, i) > 0:
+		return p.doc.autolinkAt(src, i)
+	case c == '<':
+		return rawHTML(src[i:])
+	case c == '[' || c == '!':
+		if l, ok := p.findLink(i); ok {
+			return l.n
+		}
+	}
+	return 0
+}
+
+// trimLeft returns src without the heading run of c.
+func trimLeft(src []byte, c byte) []byte {
+	for len(src) > 0 && src[0] == c {
+		src = src[1:]
+	}
+	return src
+}
+
+// flanking returns whether the delimiter run src[i:i+n] can open and close emphasis,
+// by the left and right flanking rules,'_' can't open or close emphasis inside a word,
+// other delimiters follow the rules of '*'.
+func flanking(src []byte, i, n int) (canOpen, canClose bool) {
+	before, after := ' ', ' '
+	if i > 0 {
+		before, _ = utf8.DecodeLastRune(src[:i])
+	}
+	if i+n < len(src) {
+		after, _ = utf8.DecodeRune(src[i+n:])
+	}
+	left := !unicode.IsSpace(after) && (!isPunctuation(after) || unicode.IsSpace(before) || isPunctuation(before))
+	right := !unicode.IsSpace(before) && (!isPunctuation(before) || unicode.IsSpace(after) || isPunctuation(after))
+	if src[i] != '_' {
+		return left, right
+	}
+	return left && (!right || isPunctuation(before)), right && (!left || isPunctuation(after))
+}
+
+// isPunctuation returns true if r is an unicode punctuation or symbol character.
+func isPunctuation(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// processEmphasis matches closers with the nearest openers,
+// and returns the matches in the order they are made.
+// openers not matched by a closer are not looked for again by closers of the same kind,
+// so that the delimiters are processed in linear time.
+// see https://spec.commonmark.org/0.31.2/#phase-2-inline-structure
+func processEmphasis(delims []*delimiter) []match {
+	var matches []match
+	for i, d := range delims {
+		d.prev, d.next = i-1, i+1
+	}
+	// remove links the neighbours of the delimiters from..to in the stack.
+	remove := func(from, to int) {
+		prev, next := delims[from].prev, delims[to].next
+		if prev >= 0 {
+			delims[prev].next = next
+		}
+		if next < len(delims) {
+			delims[next].prev = prev
+		}
+	}
+	// the bottom of openers by the delimiter,the length modulo 3 and whether the closer can open.
+	bottom := make(map[[3]int]int)
+	for c := 0; c < len(delims); c = delims[c].next {
+		closer := delims[c]
+		if !closer.canClose {
+			continue
+		}
+		key := [3]int{int(closer.char), closer.length % 3, 0}
+		if closer.canOpen {
+			key[2] = 1
+		}
+		floor, ok := bottom[key]
+		if !ok {
+			floor = -1
+		}
+		for closer.closed < closer.length {
+			o := closer.prev
+			for ; o > floor; o = delims[o].prev {
+				opener := delims[o]
+				if !opener.canOpen || opener.open == 0 || opener.char != closer.char {
+					continue
+				}
+				// the spans of the extensions are closed by a run of the same length.
+				if c := opener.char; c != '*' && c != '_' {
+					if opener.length == closer.length && !(opener.nospace && closer.space > opener.pos) {
+						break
+					}
+					continue
+				}
+				// the rule of 3.
+				if (opener.canClose || closer.canOpen) && (opener.length+closer.length)%3 == 0 &&
+					(opener.length%3 != 0 || closer.length%3 != 0) {
+					continue
+				}
+				break
+			}
+			if o <= floor {
+				bottom[key] = closer.prev
+				break
+			}
+			opener := delims[o]
+			n := 1
+			switch {
+			case opener.char != '*' && opener.char != '_':
+				n = closer.length
+			case opener.open >= 2 && closer.length-closer.closed >= 2:
+				n = 2
+			}
+			opener.open -= n
+			matches = append(matches, match{opener.pos + opener.closed + opener.open, closer.pos + closer.closed, n})
+			closer.closed += n
+			// delimiters between are text.
+			if delims[o].next != c {
+				remove(delims[o].next, closer.prev)
+			}
+			if opener.open == 0 {
+				remove(o, o)
+			}
+		}
+		// the rest of the closer can still open emphasis.
+		closer.open = closer.length - closer.closed
+		if closer.open == 0 || !closer.canOpen {
+			remove(c, c)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,93 @@
+package md2txt
+
+import (
+	"bytes"
+	"html"
+)
+
+// entity returns the length of the reference beginning src and the text it refers to,
+// which is &name;,&#digits; or &#xhex;,
+// n is 0 if src does not begin with a known reference.
+// see https://spec.commonmark.org/0.31.2/#entity-and-numeric-character-references
+func entity(src []byte) (n int, text []byte) {
+	if len(src) < 3 || src[0] != '&' {
+		return 0, nil
+	}
+	if src[1] == '#' {
+		return charRef(src)
+	}
+	// names are a letter followed by 1-31 letters and digits.
+	n = 1
+	for n < len(src) && n <= 33 && isAlnum(src[n]) {
+		n++
+	}
+	if n < 3 || n > 33 || !isLetter(src[1]) || n == len(src) || src[n] != ';' {
+		return 0, nil
+	}
+	ref := string(src[:n+1])
+	s := html.UnescapeString(ref)
+	// unknown names are left as they are,
+	// names beginning with a legacy name without ';',e.g. &notit;,are not known either.
+	if s == ref || s[len(s)-1] == ';' && ref != "&semi;" {
+		return 0, nil
+	}
+	return n + 1, []byte(s)
+}
+
+// charRef returns the length of the numeric character reference beginning src and its character,
+// which is &# followed by 1-7 decimal digits or x and 1-6 hexadecimal digits,
+// invalid code points and 0 are replaced by U+FFFD.
+func charRef(src []byte) (n int, text []byte) {
+	i, base, max := 2, rune(10), 7
+	if len(src) > 2 && (src[2] == 'x' || src[2] == 'X') {
+		i, base, max = 3, 16, 6
+	}
+	var r rune
+	for n = i; n < len(src) && n-i < max; n++ {
+		d := digit(src[n])
+		if d < 0 || d >= base {
+			break
+		}
+		r = r*base + d
+	}
+	if n == i || n == len(src) || src[n] != ';' {
+		return 0, nil
+	}
+	if r == 0 {
+		r = '\uFFFD'
+	}
+	// string converts surrogates and runes out of range to U+FFFD.
+	return n + 1, []byte(string(r))
+}
+
+// digit returns the value of the hexadecimal digit c,
+// returns -1 if c is not a digit.
+func digit(c byte) rune {
+	switch {
+	case c >= '0' && c <= '9':
+		return rune(c - '0')
+	case c >= 'a' && c <= 'f':
+		return rune(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return rune(c-'A') + 10
+	}
+	return -1
+}
+
+// decodeEntities replaces the references in src by the text they refer to.
+func decodeEntities(src []byte) []byte {
+	i := bytes.IndexByte(src, '&')
+	if i == -1 {
+		return src
+	}
+	out := append([]byte{}, src[:i]...)
+	for ; i < len(src); i++ {
+		if n, text := entity(src[i:]); n > 0 {
+			out = append(out, text...)
+			i += n - 1
+			continue
+		}
+		out = append(out, src[i])
+	}
+	return out
+}
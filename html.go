@@ -0,0 +1,547 @@
+package md2txt
+
+import "bytes"
+
+// tag names beginning html block of the 6th condition.
+var htmlBlockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "basefont": true,
+	"blockquote": true, "body": true, "caption": true, "center": true, "col": true,
+	"colgroup": true, "dd": true, "details": true, "dialog": true, "dir": true,
+	"div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "frame": true, "frameset": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hr": true, "html": true, "iframe": true,
+	"legend": true, "li": true, "link": true, "main": true, "menu": true,
+	"menuitem": true, "nav": true, "noframes": true, "ol": true, "optgroup": true,
+	"option": true, "p": true, "param": true, "search": true, "section": true,
+	"summary": true, "table": true, "tbody": true, "td": true, "tfoot": true,
+	"th": true, "thead": true, "title": true, "tr": true, "track": true, "ul": true,
+}
+
+// tags whose content is raw text,beginning html block of the 1st condition.
+var rawTextTags = []string{"pre", "script", "style", "textarea"}
+
+// htmlTag returns the length of the open or closing tag beginning src,
+// which is <name attribute... /> or </name>,
+// returns 0 if src does not begin with a tag.
+// see https://spec.commonmark.org/0.31.2/#raw-html
+func htmlTag(src []byte) int {
+	if len(src) < 3 || src[0] != '<' {
+		return 0
+	}
+	if src[1] == '/' {
+		n := 2 + tagName(src[2:])
+		if n == 2 {
+			return 0
+		}
+		if n = skipWhitespace(src, n); n < len(src) && src[n] == '>' {
+			return n + 1
+		}
+		return 0
+	}
+	n := 1 + tagName(src[1:])
+	if n == 1 {
+		return 0
+	}
+	for {
+		_, _, k := attribute(src[n:])
+		if k == 0 {
+			break
+		}
+		n += k
+	}
+	n = skipWhitespace(src, n)
+	if n < len(src) && src[n] == '/' {
+		n++
+	}
+	if n < len(src) && src[n] == '>' {
+		return n + 1
+	}
+	return 0
+}
+
+// tagName returns the length of the tag name beginning src,
+// which is a letter followed by letters,digits and '-'.
+func tagName(src []byte) int {
+	if len(src) == 0 || !isLetter(src[0]) {
+		return 0
+	}
+	n := 1
+	for n < len(src) && (isAlnum(src[n]) || src[n] == '-') {
+		n++
+	}
+	return n
+}
+
+// attribute returns the name and value of the attribute beginning src,
+// which is whitespace,the name and an optional value following '=',
+// the value is unquoted or enclosed in quotes,which are kept,
+// n is 0 if src does not begin with an attribute.
+func attribute(src []byte) (name, value []byte, n int) {
+	n = skipWhitespace(src, 0)
+	if n == 0 || n >= len(src) || !(isLetter(src[n]) || src[n] == '_' || src[n] == ':') {
+		return nil, nil, 0
+	}
+	start := n
+	for n++; n < len(src) && (isAlnum(src[n]) || bytes.IndexByte([]byte("_.:-"), src[n]) != -1); {
+		n++
+	}
+	name = src[start:n]
+	i := skipWhitespace(src, n)
+	if i >= len(src) || src[i] != '=' {
+		return name, nil, n
+	}
+	i = skipWhitespace(src, i+1)
+	if i >= len(src) {
+		return name, nil, n
+	}
+	if q := src[i]; q == '"' || q == '\'' {
+		j := bytes.IndexByte(src[i+1:], q)
+		if j == -1 {
+			return name, nil, n
+		}
+		return name, src[i : i+j+2], i + j + 2
+	}
+	j := i
+	for j < len(src) && !isWhitespace(src[j]) && bytes.IndexByte([]byte("\"'=<>`"), src[j]) == -1 {
+		j++
+	}
+	if j == i {
+		return name, nil, n
+	}
+	return name, src[i:j], j
+}
+
+// skipWhitespace returns the index of the first character of src[i:] which is not whitespace.
+func skipWhitespace(src []byte, i int) int {
+	for i < len(src) && isWhitespace(src[i]) {
+		i++
+	}
+	return i
+}
+
+// isWhitespace returns true if c is a space,tab,line ending or form feed.
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// tag returns the lower case name of the tag beginning src,
+// and whether it is a closing tag.
+func tag(src []byte) (name string, closing bool) {
+	src = src[1:]
+	if len(src) > 0 && src[0] == '/' {
+		closing = true
+		src = src[1:]
+	}
+	var n int
+	for n < len(src) && (isAlnum(src[n]) || src[n] == '-') {
+		n++
+	}
+	return string(bytes.ToLower(src[:n])), closing
+}
+
+// attr returns the decoded value of attribute name in tag,
+// ok is false if the tag does not have it.
+func attr(tag []byte, name string) (value []byte, ok bool) {
+	for i := 1 + tagName(tag[1:]); ; {
+		n, v, k := attribute(tag[i:])
+		if k == 0 {
+			return nil, false
+		}
+		i += k
+		if !bytes.EqualFold(n, []byte(name)) {
+			continue
+		}
+		if len(v) > 1 && (v[0] == '"' || v[0] == '\'') {
+			v = v[1 : len(v)-1]
+		}
+		return decodeEntities(v), true
+	}
+}
+
+// rawHTML returns the length of raw html beginning src,
+// i.e. a tag,comment,processing instruction,declaration or CDATA section,
+// returns 0 if src does not begin with raw html.
+// see https://spec.commonmark.org/0.31.2/#raw-html
+func rawHTML(src []byte) int {
+	if len(src) < 2 || src[0] != '<' {
+		return 0
+	}
+	end := func(prefix, suffix string) int {
+		if j := bytes.Index(src[len(prefix):], []byte(suffix)); j != -1 {
+			return len(prefix) + j + len(suffix)
+		}
+		return 0
+	}
+	switch {
+	case bytes.HasPrefix(src, []byte("<!-->")):
+		return 5
+	case bytes.HasPrefix(src, []byte("<!--->")):
+		return 6
+	case bytes.HasPrefix(src, []byte("<!--")):
+		return end("<!--", "-->")
+	case src[1] == '?':
+		return end("<?", "?>")
+	case bytes.HasPrefix(src, []byte("<![CDATA[")):
+		return end("<![CDATA[", "]]>")
+	case src[1] == '!' && len(src) > 2 && (src[2]|0x20 >= 'a' && src[2]|0x20 <= 'z'):
+		return end("<!", ">")
+	}
+	return htmlTag(src)
+}
+
+// isAlnum returns true if c is an ascii letter or digit.
+func isAlnum(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9'
+}
+
+// isLetter returns true if c is an ascii letter.
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// htmlBlockStart returns the condition(1-7) of html block beginning with line,
+// returns 0 if line does not begin a html block.
+// see https://spec.commonmark.org/0.31.2/#html-blocks
+func htmlBlockStart(line []byte) int {
+	if indentation(line) > 3 {
+		return 0
+	}
+	line = bytes.TrimLeft(line, " ")
+	if len(line) < 2 || line[0] != '<' {
+		return 0
+	}
+	switch {
+	case bytes.HasPrefix(line, []byte("<!--")):
+		return 2
+	case line[1] == '?':
+		return 3
+	case bytes.HasPrefix(line, []byte("<![CDATA[")):
+		return 5
+	case line[1] == '!' && len(line) > 2 && (line[2]|0x20 >= 'a' && line[2]|0x20 <= 'z'):
+		return 4
+	}
+	name, closing := tag(line)
+	rest := line[1+len(name):]
+	if closing {
+		rest = rest[1:]
+	}
+	end := len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '>'
+	if !closing && end {
+		for _, t := range rawTextTags {
+			if name == t {
+				return 1
+			}
+		}
+	}
+	if htmlBlockTags[name] && (end || bytes.HasPrefix(rest, []byte("/>"))) {
+		return 6
+	}
+	// a complete tag on a line begins html block of the 7th condition.
+	if n := htmlTag(line); n > 0 && isBlank(line[n:]) {
+		for _, t := range rawTextTags {
+			if name == t {
+				return 0
+			}
+		}
+		return 7
+	}
+	return 0
+}
+
+// htmlBlockEnd returns true if line ends html block of the condition(1-5).
+func htmlBlockEnd(line []byte, condition int) bool {
+	switch condition {
+	case 1:
+		lower := bytes.ToLower(line)
+		for _, t := range rawTextTags {
+			if bytes.Contains(lower, []byte("</"+t+">")) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return bytes.Contains(line, []byte("-->"))
+	case 3:
+		return bytes.Contains(line, []byte("?>"))
+	case 4:
+		return bytes.IndexByte(line, '>') != -1
+	case 5:
+		return bytes.Contains(line, []byte("]]>"))
+	}
+	return false
+}
+
+// parseHTMLBlock parses html block,
+// which ends at the line satisfying the end condition,
+// or before a blank line for the 6th and 7th conditions.
+func parseHTMLBlock(p *blockParser) stateFn {
+	condition := htmlBlockStart(p.line())
+	var lines [][]byte
+	for p.cur < len(p.src) {
+		line := p.line()
+		if condition >= 6 && isBlank(line) {
+			break
+		}
+		lines = append(lines, line)
+		p.skipLine()
+		if htmlBlockEnd(line, condition) {
+			break
+		}
+	}
+	p.emit(&BlockHtml{raw: bytes.Join(lines, []byte{'\n'}), doc: p.doc})
+	return parseBegin
+}
+
+// excluded returns true if b is excluded from the output by directive comments,
+// <!-- md2txt:off --> excludes the following blocks until <!-- md2txt:on -->,
+// <!-- md2txt:skip-next --> excludes the next block.
+// directives apply to the blocks of the same container,and are not emitted themselves.
+func (p *blockParser) excluded(b Block) bool {
+	if h, ok := b.(*BlockHtml); ok {
+		if d := directive(h.raw); d != "" {
+			switch d {
+			case "off":
+				p.off = true
+			case "on":
+				p.off = false
+			case "skip-next":
+				p.skipNext = true
+			}
+			return true
+		}
+	}
+	if p.off {
+		return true
+	}
+	if p.skipNext {
+		p.skipNext = false
+		return true
+	}
+	return false
+}
+
+// directive returns off,on or skip-next of the comment controlling which blocks are converted,
+// which is <!-- md2txt:off -->,<!-- md2txt:on --> or <!-- md2txt:skip-next -->,
+// returns "" if raw is not a directive.
+func directive(raw []byte) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) < 7 || !bytes.HasPrefix(raw, []byte("<!--")) || !bytes.HasSuffix(raw, []byte("-->")) {
+		return ""
+	}
+	body := bytes.TrimSpace(raw[4 : len(raw)-3])
+	if !bytes.HasPrefix(body, []byte("md2txt:")) {
+		return ""
+	}
+	switch d := string(body[len("md2txt:"):]); d {
+	case "off", "on", "skip-next":
+		return d
+	}
+	return ""
+}
+
+// parseInlineHTML parses raw html in the text,
+// the html is removed from the text and replaced by the text it contributes.
+func parseInlineHTML(p *spanParser) spanStateFn {
+	n := rawHTML(p.src[p.cur:])
+	raw := make([]byte, n)
+	copy(raw, p.src[p.cur:p.cur+n])
+	p.src = append(p.src[:p.cur], p.src[p.cur+n:]...)
+	text := p.tagText(raw)
+	// <br> at the end of a line does not add another line.
+	if bytes.Equal(text, []byte{'\n'}) && p.peek() == '\n' {
+		text = nil
+	}
+	// html without text between spaces leaves one space,e.g. a <!-- note --> b.
+	if len(text) == 0 && p.cur > 0 && p.src[p.cur-1] == ' ' {
+		p.src = append(p.src[:p.cur], p.src[p.cur+spaces(p.src[p.cur:]):]...)
+	}
+	p.emit(&InlineHTML{p.cur, raw, text})
+	return parseSpan
+}
+
+// tagText returns the text contributed by raw html in the text,
+// a new line for <br>,alt text for <img>,and title,url of <a> at </a>
+// if the options require.
+func (p *spanParser) tagText(raw []byte) []byte {
+	if htmlTag(raw) > 0 {
+		name, closing := tag(raw)
+		switch {
+		case name == "br" && !closing:
+			return []byte{'\n'}
+		case name == "img" && !closing:
+			return p.doc.imgText(raw)
+		case name == "a" && !closing:
+			p.anchors = append(p.anchors, raw)
+		case name == "a" && len(p.anchors) > 0:
+			a := p.anchors[len(p.anchors)-1]
+			p.anchors = p.anchors[:len(p.anchors)-1]
+			return p.doc.anchorText(a)
+		}
+	}
+	return nil
+}
+
+// imgText returns the text of <img> as images of markdown.
+func (d *document) imgText(raw []byte) []byte {
+	var alt []byte
+	if d.opts.ImageAlt {
+		alt, _ = attr(raw, "alt")
+	}
+	title, _ := attr(raw, "title")
+	src, _ := attr(raw, "src")
+	return d.linkText(alt, title, src)
+}
+
+// anchorText returns title and url of <a> following its text,
+// as links of markdown.
+func (d *document) anchorText(raw []byte) []byte {
+	title, _ := attr(raw, "title")
+	href, _ := attr(raw, "href")
+	return d.linkText(nil, title, href)
+}
+
+// tags beginning a new line in text.
+var lineTags = map[string]bool{
+	"br": true, "p": true, "li": true, "div": true, "tr": true, "pre": true,
+	"ul": true, "ol": true, "dl": true, "dt": true, "dd": true, "table": true,
+	"blockquote": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "section": true, "article": true, "header": true, "footer": true,
+	"details": true, "summary": true, "figure": true, "figcaption": true,
+	"caption": true, "thead": true, "tbody": true, "tfoot": true, "main": true,
+	"nav": true, "aside": true, "address": true, "form": true, "fieldset": true,
+	"legend": true, "center": true, "dialog": true, "title": true, "option": true,
+}
+
+// htmlToText returns text of html,
+// tags are removed,comments and content of <script>,<style> are dropped,
+// <br>,<p>,<li> and other block tags begin new lines.
+// spaces are collapsed and lines are trimmed except in <pre>.
+// <img> and <a> contribute alt text,title and url as the options require,
+// entity and character references are decoded.
+func (d *document) htmlToText(src []byte) []byte {
+	var (
+		out     []byte
+		pre     int      // depth of <pre>.
+		anchors [][]byte // open <a> tags.
+	)
+	for i := 0; i < len(src); {
+		c := src[i]
+		if c != '<' {
+			switch {
+			case c == '&':
+				if n, text := entity(src[i:]); n > 0 {
+					out = append(out, text...)
+					i += n
+					continue
+				}
+				out = append(out, c)
+			case pre > 0 || c == '\n':
+				out = append(out, c)
+			case c == ' ' || c == '\t' || c == '\r':
+				if len(out) > 0 && out[len(out)-1] != ' ' && out[len(out)-1] != '\n' {
+					out = append(out, ' ')
+				}
+			default:
+				out = append(out, c)
+			}
+			i++
+			continue
+		}
+
+		rest := src[i:]
+		skip := func(end string) {
+			if j := bytes.Index(rest, []byte(end)); j != -1 {
+				i += j + len(end)
+			} else {
+				i = len(src)
+			}
+		}
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			skip("-->")
+			continue
+		case bytes.HasPrefix(rest, []byte("<![CDATA[")):
+			j := bytes.Index(rest, []byte("]]>"))
+			if j == -1 {
+				j = len(rest)
+			}
+			out = append(out, rest[9:j]...)
+			skip("]]>")
+			continue
+		case bytes.HasPrefix(rest, []byte("<?")):
+			skip("?>")
+			continue
+		case bytes.HasPrefix(rest, []byte("<!")):
+			skip(">")
+			continue
+		}
+
+		m := rest[:htmlTag(rest)]
+		if len(m) == 0 {
+			out = append(out, c)
+			i++
+			continue
+		}
+		i += len(m)
+		name, closing := tag(m)
+		switch {
+		case (name == "script" || name == "style") && !closing:
+			// drop until the closing tag.
+			for j := i; ; j++ {
+				k := bytes.Index(src[j:], []byte("</"))
+				if k == -1 {
+					i = len(src)
+					break
+				}
+				j += k
+				if n, _ := tag(src[j:]); n == name {
+					if end := bytes.IndexByte(src[j:], '>'); end != -1 {
+						i = j + end + 1
+					} else {
+						i = len(src)
+					}
+					break
+				}
+			}
+		case name == "pre":
+			if closing {
+				pre--
+			} else {
+				pre++
+			}
+			out = newLine(out)
+		case name == "img" && !closing:
+			out = append(out, d.imgText(m)...)
+		case name == "a" && !closing:
+			anchors = append(anchors, m)
+		case name == "a" && len(anchors) > 0:
+			out = append(out, d.anchorText(anchors[len(anchors)-1])...)
+			anchors = anchors[:len(anchors)-1]
+		case name == "td" || name == "th":
+			if closing {
+				out = append(out, ' ')
+			}
+		case lineTags[name]:
+			out = newLine(out)
+		}
+	}
+
+	// drop blank lines.
+	var lines [][]byte
+	for _, l := range bytes.Split(out, []byte{'\n'}) {
+		if l = bytes.TrimRight(l, " \t"); len(l) > 0 {
+			lines = append(lines, l)
+		}
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// newLine appends '\n' to out unless out ends with a new line.
+func newLine(out []byte) []byte {
+	out = bytes.TrimRight(out, " ")
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	return out
+}
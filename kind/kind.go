@@ -24,6 +24,15 @@ const (
 	Link
 	Code
 	Image
+	// kinds added later follow,so that the values above are kept.
+	HTMLBlock
+	Table
+	InlineHTML
+	LineBreak
+	Strikethrough
+	Highlight
+	Superscript
+	Subscript
 )
 
 // element types
@@ -4,9 +4,9 @@ package kind
 
 import "fmt"
 
-const _Kind_name = "HeadParagraphListQuoteBlockCodeBlockRuleEmphasisStrongLinkCodeImage"
+const _Kind_name = "HeadParagraphListQuoteBlockCodeBlockRuleEmphasisStrongLinkCodeImageHTMLBlockTableInlineHTMLLineBreakStrikethroughHighlightSuperscriptSubscript"
 
-var _Kind_index = [...]uint8{4, 13, 17, 27, 36, 40, 48, 54, 58, 62, 67}
+var _Kind_index = [...]uint8{4, 13, 17, 27, 36, 40, 48, 54, 58, 62, 67, 76, 81, 91, 100, 113, 122, 133, 142}
 
 func (i Kind) String() string {
 	if i < 0 || i >= Kind(len(_Kind_index)) {
@@ -6,43 +6,140 @@ package md2txt
 
 import (
 	"bytes"
-	"math"
-	"regexp"
+	"sort"
+	"strconv"
+	"sync"
 	"unicode"
 	"unicode/utf8"
-
-	"github.com/zouhuigang/md2txt/kind"
 )
 
+// EXT is the markdown the source is written in,
+// extensions are flags which can be combined with CommonMark,e.g. CommonMark|ExtAutolink.
 type EXT int
 
 const (
-	BASIC EXT = iota // Basic Markdown based on http://daringfireball.net/projects/markdown/syntax
-	//GFM              // Github Flavored Markdown
+	BASIC            EXT = 0         // Basic Markdown based on http://daringfireball.net/projects/markdown/syntax
+	CommonMark       EXT = 1 << iota // CommonMark based on https://spec.commonmark.org
+	ExtAutolink                      // bare urls,www. links and emails of GFM.
+	ExtTable                         // pipe tables of GFM.
+	ExtStrikethrough                 // ~~deleted~~ of GFM.
+	ExtHighlight                     // ==highlight==.
+	ExtSuperscript                   // ^sup^.
+	ExtSubscript                     // ~sub~,strikethrough needs two tildes then.
+
+	// Github Flavored Markdown based on https://github.github.com/gfm
+	GFM = CommonMark | ExtAutolink | ExtTable | ExtStrikethrough
+)
+
+// Options controls how markdown is converted to text.
+type Options struct {
+	Ext       EXT  // extension the source is written in.
+	ImageAlt  bool // images contribute their alt text.
+	LinkTitle bool // links and images contribute their titles.
+	LinkURL   bool // links and images contribute their urls.
+
+	LineEnding LineEnding // line ending of the text.
+	SoftBreak  SoftBreak  // how lines of a paragraph are joined.
+	TableStyle TableStyle // how cells of tables are laid out.
+
+	// how the text of the spans of extensions is rendered.
+	Strikethrough SpanStyle
+	Highlight     SpanStyle
+	Superscript   SpanStyle
+	Subscript     SpanStyle
+}
+
+// SpanStyle tells how the text of a span is rendered.
+type SpanStyle int
+
+const (
+	SpanText   SpanStyle = iota // the text is kept without the delimiters.
+	SpanDrop                    // the span is dropped with its text.
+	SpanMarked                  // the text is kept with the delimiters,e.g. ~~text~~.
+)
+
+// TableStyle tells how cells of tables are laid out.
+type TableStyle int
+
+const (
+	TableAligned     TableStyle = iota // rows on lines,columns padded to the same width.
+	TableCellPerLine                   // cells on lines,rows separated by blank lines,for narrow output.
 )
 
+// SoftBreak tells how lines of a paragraph are joined.
+type SoftBreak int
+
 const (
-	tab    = "\t"
-	sapce4 = "    "
+	SoftBreakPreserve SoftBreak = iota // lines are kept.
+	SoftBreakSpace                     // lines are joined with a space.
+	SoftBreakSmart                     // lines are joined with a space,or without one between CJK characters.
 )
 
+// LineEnding is the line ending of the text.
+type LineEnding int
+
+const (
+	LF   LineEnding = iota // "\n"
+	CRLF                   // "\r\n"
+)
+
+// DefaultOptions returns the options Parse uses for ext,
+// links and images contribute text,title and url unless CommonMark is enabled,
+// e.g. in BASIC and BASIC with ExtTable,but only text in CommonMark and GFM.
+func DefaultOptions(ext EXT) Options {
+	opts := Options{Ext: ext, ImageAlt: true}
+	if ext&CommonMark == 0 {
+		opts.LinkTitle, opts.LinkURL = true, true
+	}
+	return opts
+}
+
 // state for block parser.
 type stateFn func(p *blockParser) stateFn
 
 // state for span parser.
 type spanStateFn func(p *spanParser) spanStateFn
 
+// document holds the state shared by all the parsers working on one source.
+type document struct {
+	opts Options
+
+	mu   sync.Mutex
+	refs map[string]*reference // link reference definitions by normalized label.
+}
+
+// commonMark reports whether the CommonMark rules are in force.
+func (d *document) commonMark() bool { return d.has(CommonMark) }
+
+// has reports whether the extension ext is enabled.
+func (d *document) has(ext EXT) bool { return d != nil && d.opts.Ext&ext != 0 }
+
+// linkText returns text of a link or image,
+// followed by title and url if the options require.
+func (d *document) linkText(text, title, url []byte) []byte {
+	parts := [][]byte{text}
+	if d.opts.LinkTitle {
+		parts = append(parts, title)
+	}
+	if d.opts.LinkURL {
+		parts = append(parts, url)
+	}
+	return bytes.Join(parts, []byte{})
+}
+
 // parser is a main part for a parsing procedure,
 // it provides convinient methods for parsing.
 type parser struct {
+	doc *document
 	src []byte // source bytes slice.
 
 	start  int // start index.
 	cur    int // current index.
 	length int // length of scanned content.
+	first  int // line number of src[0] in the document.
 }
 
-// reference is used in link or image,
+// reference is defined by link reference definitions,
 // it's format is [id]: url "title".
 type reference struct {
 	link  []byte
@@ -52,9 +149,15 @@ type reference struct {
 // span parser aims at span elements parsing.
 type spanParser struct {
 	*parser
-	ref      map[string]*reference
-	state    spanStateFn
-	spanChan chan Span
+	anchors   [][]byte      // open <a> tags of inline html.
+	matches   map[int]match // emphasis by the index of the opening delimiters,nil until parsed.
+	matched   int           // length of the source when the emphasis is matched.
+	brackets  []bracket     // brackets in the order of '[',nil until found.
+	bracketed int           // length of the source when the brackets are found.
+	last      Span          // the span emitted last.
+	lastAt    int           // index the last span is cut from.
+	state     spanStateFn
+	spanChan  chan Span
 }
 
 // element gets a span from the channel,
@@ -63,6 +166,7 @@ func (p *spanParser) element() Span { return <-p.spanChan }
 
 // emit emits a span element to the channel.
 func (p *spanParser) emit(s Span) {
+	p.last, p.lastAt = s, p.cur
 	p.spanChan <- s
 	p.start = p.cur
 }
@@ -80,6 +184,14 @@ type blockParser struct {
 	*parser
 	state     stateFn
 	blockChan chan Block
+
+	depth   int  // number of quotes src is nested in.
+	emitted bool // some block has been emitted.
+	blank   bool // blank lines follow the last emitted block.
+	loose   bool // blank lines separate some blocks.
+
+	off      bool // blocks are excluded by <!-- md2txt:off -->.
+	skipNext bool // the next block is excluded by <!-- md2txt:skip-next -->.
 }
 
 // element gets a block from the channel,
@@ -88,8 +200,15 @@ func (p *blockParser) element() Block { return <-p.blockChan }
 
 // emit emits a block element to the channel.
 func (p *blockParser) emit(b Block) {
-	p.blockChan <- b
+	if p.blank {
+		p.loose = true
+	}
+	p.emitted, p.blank = true, false
 	p.start = p.cur
+	if p.excluded(b) {
+		return
+	}
+	p.blockChan <- b
 }
 
 // run is the main procedure of the state machine for block elements parsing,
@@ -101,45 +220,70 @@ func (p *blockParser) run() {
 	close(p.blockChan)
 }
 
-// newParser returns a blockParser for parsing src.
+// newParser returns a blockParser for parsing src as BASIC markdown.
 func newParser(src []byte) *blockParser {
+	return (&document{opts: DefaultOptions(BASIC)}).newParser(src, 1)
+}
+
+// newSpanParser returns a spanParser for parsing src as BASIC markdown.
+func newSpanParser(src []byte) *spanParser {
+	return (&document{opts: DefaultOptions(BASIC)}).newSpanParser(src)
+}
+
+// newParser returns a blockParser for parsing src within the document d,
+// line is the line number src begins at.
+func (d *document) newParser(src []byte, line int) *blockParser {
+	return d.newNestedParser(src, line, 0)
+}
+
+// newNestedParser returns a blockParser for parsing src nested in depth quotes.
+func (d *document) newNestedParser(src []byte, line, depth int) *blockParser {
 	p := &parser{
-		src: src,
+		doc:   d,
+		src:   src,
+		first: line,
 	}
-	bp := &blockParser{parser: p, blockChan: make(chan Block)}
+	bp := &blockParser{parser: p, depth: depth, blockChan: make(chan Block)}
 	go bp.run()
 	return bp
 }
 
-// newSpanParser returns a spanParser for parsing src.
-func newSpanParser(src []byte) *spanParser {
+// newSpanParser returns a spanParser for parsing src within the document d.
+func (d *document) newSpanParser(src []byte) *spanParser {
 	p := &parser{
+		doc: d,
 		src: src,
 	}
-	sp := &spanParser{parser: p, ref: make(map[string]*reference), spanChan: make(chan Span)}
+	sp := &spanParser{parser: p, spanChan: make(chan Span)}
 	go sp.run()
 	return sp
 }
 
-const eof = -1
-
-// consume repeatly consume rune equal to r.
-func (p *parser) consume(r rune, limit ...int64) int {
-	if len(limit) > 1 {
-		panic("only one argument is allowed")
-	}
-	var max int64 = math.MaxInt64
-	if len(limit) == 1 {
-		max = limit[0]
+// spans returns the spans of src and the text without them,
+// StartPos of a span is the index it is cut from the text.
+func (d *document) spans(src []byte) (text []byte, spans []Span) {
+	// spans are cut from the source,parse a copy to keep src intact.
+	sp := d.newSpanParser(append([]byte(nil), src...))
+	for s := sp.element(); s != nil; s = sp.element() {
+		spans = append(spans, s)
 	}
-	var count int
-	for pr := p.peek(); pr == r && count < int(max); {
-		pr = p.next()
-		count++
+	return sp.src, spans
+}
+
+// spanText returns the text of src with the spans replaced by their contents.
+func (d *document) spanText(src []byte) []byte {
+	text, spans := d.spans(src)
+	var length int
+	for _, v := range spans {
+		content, pos := v.Content(), length+v.StartPos()
+		text = append(text[:pos], append(content, text[pos:]...)...)
+		length += len(content)
 	}
-	return count
+	return text
 }
 
+const eof = -1
+
 // merge escape runes(like \*,\_),to one rune.
 func (p *parser) merge() {
 	if p.cur+1 >= len(p.src) {
@@ -166,34 +310,6 @@ func (p *parser) next() rune {
 	return r
 }
 
-// forsee run of runes.
-func (p *parser) forsee(rs ...rune) bool {
-	pos := p.cur
-	for k := 0; k < len(rs); k++ {
-		r, w := utf8.DecodeRune(p.src[pos:])
-		pos += w
-		if r != rs[k] {
-			return false
-		}
-	}
-	return true
-}
-
-// runes return the runes consisting of the string.
-func runes(s string) []rune {
-	var runes []rune
-	for {
-		r, w := utf8.DecodeRuneInString(s)
-		if w != 0 {
-			runes = append(runes, r)
-			s = s[w:]
-		} else {
-			break
-		}
-	}
-	return runes
-}
-
 // peek peek nth rune from the p.cur,
 // default is 1.
 func (p *parser) peek(i ...int) rune {
@@ -221,10 +337,29 @@ func (p *parser) peek(i ...int) rune {
 	}
 }
 
-// backup backup a rune to the src.
-func (p *parser) backup() {
-	//p.pos.Colunm -= p.length
-	p.cur -= p.length
+// line returns the rest of current line without '\n'.
+func (p *parser) line() []byte { return lineAt(p.src, p.cur) }
+
+// lineAt returns the line of src beginning at i without '\n'.
+func lineAt(src []byte, i int) []byte {
+	l := src[i:]
+	if i := bytes.IndexByte(l, '\n'); i != -1 {
+		l = l[:i]
+	}
+	return l
+}
+
+// skipLine moves to the beginning of next line.
+func (p *parser) skipLine() {
+	p.cur += len(p.line())
+	if p.cur < len(p.src) {
+		p.cur++
+	}
+}
+
+// lineOf returns the line number of src[i] in the document.
+func (p *parser) lineOf(i int) int {
+	return p.first + numberOfLines(p.src[:i])
 }
 
 // lines returns line number of the input
@@ -238,289 +373,584 @@ func numberOfLines(input []byte) int {
 	return count
 }
 
-// parseHead parse head beginning with '#'
-func parseHead(p *blockParser) stateFn {
-	level := p.consume('#')
-	for r := p.next(); r != '\n' && r != eof; {
-		r = p.next()
+// tab stops are every 4 columns.
+const tabStop = 4
+
+// indentation returns the width of heading whitespace of line,
+// a tab advances to the next tab stop.
+func indentation(line []byte) int {
+	var col int
+	for _, c := range line {
+		switch c {
+		case ' ':
+			col++
+		case '\t':
+			col += tabStop - col%tabStop
+		default:
+			return col
+		}
+	}
+	return col
+}
+
+// stripIndent removes n columns of heading whitespace of line,
+// which begins at column col,
+// a tab partially removed leaves the rest of its columns as spaces.
+func stripIndent(line []byte, col, n int) []byte {
+	var i, width int
+	for ; i < len(line) && width < n; i++ {
+		switch line[i] {
+		case ' ':
+			width++
+		case '\t':
+			w := tabStop - (col+width)%tabStop
+			if width+w > n {
+				rest := bytes.Repeat([]byte{' '}, width+w-n)
+				return append(rest, line[i+1:]...)
+			}
+			width += w
+		default:
+			return line[i:]
+		}
 	}
-	content := p.src[p.start:p.cur]
-	// deliminate headiing and tailing shaps or spaces.
-	content = bytes.TrimFunc(content, func(r rune) bool {
-		if r == '#' || r == ' ' {
-			return true
+	return line[i:]
+}
+
+// expandIndent replaces tabs of heading whitespace of line by spaces,
+// line begins at column col.
+func expandIndent(line []byte, col int) []byte {
+	ws := len(line) - len(bytes.TrimLeft(line, " \t"))
+	if bytes.IndexByte(line[:ws], '\t') == -1 {
+		return line
+	}
+	width := col
+	for _, c := range line[:ws] {
+		if c == '\t' {
+			width += tabStop - width%tabStop
+		} else {
+			width++
 		}
-		return false
-	})
-	content = bytes.TrimSpace(content)
-	head := &Head{level, content}
+	}
+	return append(bytes.Repeat([]byte{' '}, width-col), line[ws:]...)
+}
+
+// containerLine returns line of a container without n columns of its prefix,
+// the content begins at column col+n,
+// its heading tabs are expanded unless it begins at a tab stop,
+// so that the content can be parsed from column 0.
+func containerLine(line []byte, col, n int) []byte {
+	line = stripIndent(line, col, n)
+	if (col+n)%tabStop != 0 {
+		line = expandIndent(line, col+n)
+	}
+	return line
+}
+
+// isBlank returns true if line has only spaces or tabs.
+func isBlank(line []byte) bool {
+	return len(bytes.Trim(line, " \t")) == 0
+}
+
+// atxHead returns level and content of a head beginning with 1-6 '#',
+// indented by no more than three spaces.
+// the opening sequence must be followed by a space in CommonMark,
+// and the optional closing sequence must be preceded by a space.
+func atxHead(line []byte, commonMark bool) (level int, content []byte, ok bool) {
+	if indentation(line) > 3 {
+		return
+	}
+	line = bytes.TrimLeft(line, " ")
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, nil, false
+	}
+	content = line[level:]
+	if commonMark && len(content) > 0 && content[0] != ' ' && content[0] != '\t' {
+		return 0, nil, false
+	}
+	content = bytes.Trim(content, " \t")
+	// deliminate the closing sequence,unless it is escaped.
+	end := len(bytes.TrimRight(content, "#"))
+	switch {
+	case end == 0:
+		content = content[:0]
+	case content[end-1] == ' ' || content[end-1] == '\t':
+		content = bytes.TrimRight(content[:end], " \t")
+	case !commonMark && content[end-1] != '\\':
+		content = content[:end]
+	}
+	return level, content, true
+}
+
+// parseHead parse head beginning with '#'
+func parseHead(p *blockParser) stateFn {
+	level, content, _ := atxHead(p.line(), p.doc.commonMark())
+	p.skipLine()
+	head := &Head{level, content, p.doc}
 	p.emit(head)
 	return parseBegin
 }
 
+// setextLevel returns 1 for a line of '=' and 2 for a line of '-',
+// which underlines a paragraph as a head,
+// returns 0 if line is not an underline.
+func setextLevel(line []byte) int {
+	if indentation(line) > 3 {
+		return 0
+	}
+	line = bytes.Trim(line, " \t")
+	if len(line) == 0 || len(bytes.Trim(line, string(line[:1]))) != 0 {
+		return 0
+	}
+	switch line[0] {
+	case '=':
+		return 1
+	case '-':
+		return 2
+	}
+	return 0
+}
+
+// isRule returns true if line is a horizontal rule,
+// which has at least three '*'|'-'|'_' and optional spaces between them.
+func isRule(line []byte) bool {
+	if indentation(line) > 3 {
+		return false
+	}
+	line = bytes.TrimLeft(line, " ")
+	if len(line) == 0 || (line[0] != '*' && line[0] != '-' && line[0] != '_') {
+		return false
+	}
+	var n int
+	for _, c := range line {
+		switch c {
+		case line[0]:
+			n++
+		case ' ', '\t':
+		default:
+			return false
+		}
+	}
+	return n >= 3
+}
+
+// interrupt returns true if line begins a block which ends a paragraph.
+func (p *blockParser) interrupt(line []byte) bool {
+	if isRule(line) {
+		return true
+	}
+	if _, _, ok := atxHead(line, p.doc.commonMark()); ok {
+		return true
+	}
+	if _, _, _, ok := fence(line); ok {
+		return true
+	}
+	// html blocks except the 7th condition.
+	if c := htmlBlockStart(line); c > 0 && c < 7 {
+		return true
+	}
+	// an item which is not empty and begins with 1 if ordered.
+	if m, ok := listItem(line); ok && !m.empty && (!m.ordered || m.start == 1) {
+		return true
+	}
+	return indentation(line) <= 3 && bytes.HasPrefix(bytes.TrimLeft(line, " "), []byte{'>'})
+}
+
 // parse text with no prefix.
 // NOTICE:if followed by '---'|'====',
 // emitted as Head Type else Paragraph Type.
 func parseParagraph(p *blockParser) stateFn {
-	for r := p.next(); r != eof; r = p.next() {
-		if r == '\n' {
-			r = p.peek()
+	var (
+		lines     [][]byte
+		level     int
+		underline []byte
+	)
+	for p.cur < len(p.src) {
+		line := p.line()
+		// a table begins with the line,which ends the paragraph.
+		if p.isTable() {
+			if len(lines) == 0 {
+				return parseTable
+			}
+			break
+		}
+		if len(lines) > 0 {
+			if isBlank(line) {
+				break
+			}
 			// Head type has tailling ----- (H2) or ====== (H1)
-			if r == '-' || r == '=' {
-				p.consume(r)
-				r1 := p.peek(2)
-				if r1 == '\n' {
-					p.next()
-				}
-				content := p.src[p.start:p.cur]
-				content = regexp.MustCompile("\n?"+string(r)+"*\n?").ReplaceAll(content, []byte{})
-
-				var level int
-				if r == '-' {
-					level = 2
-				}
-				if r == '=' {
-					level = 1
-				}
-
-				head := &Head{level, content}
-				p.emit(head)
-				return parseBegin
+			if level = setextLevel(line); level > 0 {
+				underline = line
+				p.skipLine()
+				break
 			}
-			if r == '\n' {
-				p.next()
-				goto emit
+			if p.interrupt(line) {
+				break
 			}
 		}
+		lines = append(lines, bytes.TrimLeft(line, " \t"))
+		p.skipLine()
+	}
+	content := bytes.Join(lines, []byte{'\n'})
+	content = bytes.TrimRight(content, " \t")
+	// link reference definitions begin the paragraph.
+	if content = p.doc.defineRefs(content); len(content) == 0 {
+		if level > 0 {
+			// only definitions precede the underline,which is a paragraph then.
+			p.emit(&Paragraph{content: bytes.TrimSpace(underline), doc: p.doc})
+		}
+		return parseBegin
 	}
-emit:
-	content := p.src[p.start:p.cur]
-	content = regexp.MustCompile("\n{0,2}$").ReplaceAll(content, []byte{})
-	paragraph := &Paragraph{content: content}
+	if level > 0 {
+		head := &Head{level, content, p.doc}
+		p.emit(head)
+		return parseBegin
+	}
+	paragraph := &Paragraph{content: content, doc: p.doc}
 	p.emit(paragraph)
 	return parseBegin
-
 }
 
-// parseOrderlist parses order lists with embedded sub elements.
-func parseList(p *blockParser, reg *regexp.Regexp) stateFn {
-	list := &List{}
-	start := p.start
-	for {
-		var (
-			blocks []Block
-			n      int
-		)
-		for r := p.next(); r != eof; {
-			if r == '\n' {
-				r1 := p.peek()
-
-				if r1 == eof ||
-					reg.Match(p.src[p.cur:]) {
-					break
-				}
-				if p.forsee('\n', ' ', ' ', ' ', ' ') || p.forsee('\n', '\t') {
-					blocks, n = parseItemBlocks(p.src[p.cur:])
-					p.src = append(p.src[:p.cur], p.src[p.cur+n:]...)
-					break
-				}
-				if r1 == '\n' {
-					break
-				}
-
-				// judge if item has mutiple lines.
-				if p.forsee(' ', ' ', ' ', ' ') {
-					p.src = append(p.src[:p.cur], p.src[p.cur+4:]...)
-					continue
-				}
-				if p.forsee('\t') {
-					p.src = append(p.src[:p.cur], p.src[p.cur+1:]...)
-					continue
-				}
+// listMarker is the marker beginning a list item.
+type listMarker struct {
+	ordered bool
+	bullet  byte // '-'|'+'|'*' of unorder list,'.'|')' of order list.
+	start   int  // number of order list item.
+	marker  int  // width of indentation and marker.
+	width   int  // width of indentation,marker and following spaces.
+	empty   bool // item begins with a blank line.
+}
 
-			}
-			r = p.next()
+// listItem returns the marker if line begins a list item,
+// which is '-'|'+'|'*' or 1-9 digits followed by '.'|')',
+// and then spaces or the end of line.
+func listItem(line []byte) (m listMarker, ok bool) {
+	indent := indentation(line)
+	if indent > 3 || indent == len(line) || isRule(line) {
+		return
+	}
+	rest := line[indent:]
+	var n int
+	switch rest[0] {
+	case '-', '+', '*':
+		m.bullet = rest[0]
+		n = 1
+	default:
+		for n < len(rest) && n < 10 && rest[n] >= '0' && rest[n] <= '9' {
+			n++
 		}
-		content := p.src[start:p.cur]
-		content = reg.ReplaceAll(content, []byte{})
-		content = bytes.TrimRightFunc(content, func(r rune) bool {
-			if r == '\n' {
-				return true
-			}
-			return false
-		})
+		if n == 0 || n > 9 || n == len(rest) || (rest[n] != '.' && rest[n] != ')') {
+			return
+		}
+		m.ordered = true
+		m.start, _ = strconv.Atoi(string(rest[:n]))
+		m.bullet = rest[n]
+		n++
+	}
+	rest = rest[n:]
+	m.marker = indent + n
+	spaces := indentation(expandIndent(rest, m.marker))
+	switch {
+	case isBlank(rest):
+		m.empty = true
+		spaces = 1
+	case spaces == 0:
+		return listMarker{}, false
+	case spaces > 4:
+		// content begins with indented code.
+		spaces = 1
+	}
+	m.width = m.marker + spaces
+	return m, true
+}
+
+// itemContent returns line beginning an item of m without the marker.
+func itemContent(line []byte, m listMarker) []byte {
+	line = containerLine(line[m.marker:], m.marker, m.width-m.marker)
+	if isBlank(line) {
+		return nil
+	}
+	return line
+}
 
-		item := &Item{content: content}
-		item.subBlocks = blocks
+// sameList returns true if line begins an item following the item of m.
+func sameList(line []byte, m listMarker) bool {
+	n, ok := listItem(line)
+	return ok && indentation(line) < m.width && n.ordered == m.ordered && n.bullet == m.bullet
+}
+
+// parseList parses lists with embedded sub elements,
+// an item of another marker begins a new list.
+func parseList(p *blockParser) stateFn {
+	m, _ := listItem(p.line())
+	list := &List{ordered: m.ordered, bullet: m.bullet, start: m.start, tight: true}
+	for {
+		item, loose, blank := parseItem(p, m)
 		list.items = append(list.items, item)
-		// if forsee Sprinf("%s ",marker),
+		// list is loose if blank lines separate items,
+		// or blocks directly in an item.
+		if loose {
+			list.tight = false
+		}
+		// if forsee an item of the same marker,
 		// parse another list item,else emit list.
-		if !reg.Match(p.src[p.cur:]) {
+		line := p.line()
+		if !sameList(line, m) {
 			p.emit(list)
 			return parseBegin
 		}
-		start = p.cur
+		if blank {
+			list.tight = false
+		}
+		m, _ = listItem(line)
 	}
-
 }
 
-// parse sub blocks under the list item.
-func parseItemBlocks(src []byte) ([]Block, int) {
-	// TODO: support lazy mode.
-	var count int
-	end := bytes.LastIndex(src, []byte("\n\n"))
-	// check if face the end of last line or file.
-	if end == -1 {
-		if bytes.LastIndex(src, []byte{'\n'}) == len(src)-1 {
-			end = len(src) - 1
-		} else {
-			end = len(src)
+// parseItem parses an item beginning with marker m as a container,
+// which holds the lines indented as its content and lazy lines continuing a paragraph,
+// their indentation is removed and the content is parsed as blocks.
+// loose reports whether blank lines separate the blocks,
+// and blank whether blank lines follow the item.
+func parseItem(p *blockParser, m listMarker) (item *Item, loose, blank bool) {
+	var (
+		lines [][]byte
+		para  paragraphTracker
+		first = p.lineOf(p.cur)
+	)
+	line := itemContent(p.line(), m)
+	lines = append(lines, line)
+	para.add(line)
+	p.skipLine()
+
+	for p.cur < len(p.src) {
+		line = p.line()
+		switch {
+		case isBlank(line):
+			// item can begin with at most one blank line.
+			if len(lines) == 1 && m.empty {
+				goto end
+			}
+			line = nil
+		case indentation(line) >= m.width:
+			line = containerLine(line, 0, m.width)
+		case para.open && !p.interrupt(line):
+			// an item of the list is not lazy.
+			if _, ok := listItem(line); ok {
+				goto end
+			}
+			// lazy continuation line.
+		default:
+			goto end
 		}
-		count = len(src)
-	} else {
-		count = end + 2
+		lines = append(lines, line)
+		para.add(line)
+		p.skipLine()
+	}
+end:
+	// trailing blank lines separate items.
+	for len(lines) > 1 && lines[len(lines)-1] == nil {
+		lines = lines[:len(lines)-1]
+		blank = true
+	}
+	item = &Item{}
+	np := p.doc.newNestedParser(bytes.Join(lines, []byte{'\n'}), first, p.depth)
+	for b := np.element(); b != nil; b = np.element() {
+		item.subBlocks = append(item.subBlocks, b)
 	}
+	return item, np.loose, blank
+}
 
-	var blocks []Block
-	blockBytes := bytes.Split(src[:end], []byte("\n\n"))
-
-	for _, b := range blockBytes {
-		i := bytes.IndexByte(b, '\n')
-		if i < len(b)-1 && bytes.Equal(b[i+1:i+2], []byte("\t")) ||
-			i < len(b)-4 && bytes.Equal(b[i+1:i+5], []byte("    ")) {
-			// not lazy mode
-			// replace every line's heading indents.
-			b = regexp.MustCompile(`(?m)(^    |\t)`).ReplaceAll(b, []byte{})
-		} else {
-			if b[0] == ' ' {
-				b = b[4:]
-			}
-			if b[0] == '\t' {
-				b = b[1:]
+// paragraphTracker tells whether lines of a container end in an open paragraph,
+// which a lazy continuation line can be appended to.
+type paragraphTracker struct {
+	open   bool
+	marker byte // marker of an open code fence.
+	n      int  // length of the open code fence.
+}
+
+// add updates the state with the following line.
+func (t *paragraphTracker) add(line []byte) {
+	// look into nested quotes and items.
+	for {
+		if m, ok := listItem(line); ok {
+			if line = itemContent(line, m); line == nil {
+				break
 			}
+			continue
+		}
+		if content, ok := quoteLine(line); ok {
+			line = content
+			continue
 		}
-		p := newParser(b)
-		e := p.element()
-		if e != nil {
-			blocks = append(blocks, e)
+		break
+	}
+	if t.marker != 0 {
+		if isClosingFence(line, t.marker, t.n) {
+			t.marker = 0
 		}
+		return
+	}
+	if marker, n, _, ok := fence(line); ok {
+		t.open, t.marker, t.n = false, marker, n
+		return
+	}
+	switch {
+	case isBlank(line):
+		t.open = false
+	case !t.open && indentation(line) > 3:
+		// indented code.
+	case isRule(line) || (t.open && setextLevel(line) > 0):
+		t.open = false
+	default:
+		_, _, head := atxHead(line, false)
+		t.open = !head
 	}
-	return blocks, count
 }
 
-// parseUnorderList parses unorder lists with embedded sub elements.
-func parseUnorderList(p *blockParser) stateFn {
-	marker := p.peek()
-	escape := ""
-	if marker == '+' || marker == '*' {
-		escape = "\\"
+// parseCodeBlock parses code indented by at least 4 columns,
+// the 4 columns of indentation are removed from every line.
+// blank lines are kept inside the code,but not at its end.
+func parseCodeBlock(p *blockParser) stateFn {
+	codeBlock := &CodeBlock{line: p.lineOf(p.start)}
+	var (
+		lines [][]byte
+		n     int     // number of lines up to the last one which is not blank.
+		end   = p.cur // end of the last line which is not blank.
+	)
+	for p.cur < len(p.src) {
+		line := p.line()
+		if !isBlank(line) && indentation(line) < 4 {
+			break
+		}
+		lines = append(lines, stripIndent(line, 0, 4))
+		p.skipLine()
+		if !isBlank(line) {
+			n, end = len(lines), p.cur
+		}
 	}
-	return parseList(p, regexp.MustCompile("^"+escape+string(marker)+"   "))
+	codeBlock.content = bytes.Join(lines[:n], []byte{'\n'})
+	// trailing blank lines are left to separate blocks.
+	p.cur = end
+	p.emit(codeBlock)
+	return parseBegin
 }
 
-// parseOrderList parses order lists with embedded sub elements.
-func parseOrderList(p *blockParser) stateFn {
-	return parseList(p, regexp.MustCompile(`^\d+\.  `))
+// fence returns the marker,length and info string of an opening code fence,
+// which is a run of at least three '`' or '~' indented by no more than three spaces.
+func fence(line []byte) (marker byte, n int, info []byte, ok bool) {
+	indent := len(line) - len(bytes.TrimLeft(line, " "))
+	if indent > 3 || indent == len(line) {
+		return
+	}
+	marker = line[indent]
+	if marker != '`' && marker != '~' {
+		return
+	}
+	for n = 0; indent+n < len(line) && line[indent+n] == marker; n++ {
+	}
+	if n < 3 {
+		return
+	}
+	info = bytes.TrimSpace(line[indent+n:])
+	// info string of backtick fence can't contain backticks.
+	if marker == '`' && bytes.IndexByte(info, '`') != -1 {
+		return
+	}
+	return marker, n, info, true
 }
 
-// parseCode parses code beginning with 4 sapces or 1 tab.
-func parseCodeBlock(p *blockParser) stateFn {
-	codeBlock := &CodeBlock{}
-	start := p.start
-	var marker string
-	r := p.peek()
-	marker = "\t"
-	if r == ' ' {
-		marker = "    " // 4 spaces
+// isClosingFence returns true if line closes the fence of n markers.
+func isClosingFence(line []byte, marker byte, n int) bool {
+	line = bytes.TrimRight(line, " \t")
+	indent := len(line) - len(bytes.TrimLeft(line, " "))
+	if indent > 3 || len(line)-indent < n {
+		return false
 	}
-	for {
-		for r := p.next(); r != '\n' && r != eof; {
-			r = p.next()
-		}
-		content := p.src[start:p.cur]
-		content = regexp.MustCompile("^"+marker).ReplaceAll(content, []byte{})
-		content = bytes.TrimRightFunc(content, func(r rune) bool {
-			if r == '\n' {
-				return true
-			}
+	for _, c := range line[indent:] {
+		if c != marker {
 			return false
-		})
-		codeBlock.content = append(codeBlock.content, content...)
-		if !p.forsee(runes(marker)...) {
+		}
+	}
+	return true
+}
+
+// parseFencedCode parses code between fences of '`' or '~',
+// the first word of the info string is the language.
+// an unclosed fence runs to the end of the source.
+func parseFencedCode(p *blockParser) stateFn {
+	line := p.line()
+	indent := len(line) - len(bytes.TrimLeft(line, " "))
+	marker, n, info, _ := fence(line)
+	p.skipLine()
+
+	codeBlock := &CodeBlock{line: p.lineOf(p.start), info: info, fenced: true}
+	if i := bytes.IndexAny(info, " \t"); i != -1 {
+		codeBlock.lang = info[:i]
+	} else {
+		codeBlock.lang = info
+	}
+	var lines [][]byte
+	for p.cur < len(p.src) {
+		line = p.line()
+		p.skipLine()
+		if isClosingFence(line, marker, n) {
 			break
 		}
-		start = p.cur
+		// remove the indentation of the opening fence.
+		lines = append(lines, stripIndent(line, 0, indent))
 	}
+	codeBlock.content = bytes.Join(lines, []byte{'\n'})
 	p.emit(codeBlock)
 	return parseBegin
-
 }
 
 // parseRule parses rule begining,
 // with more than three '*'|'+'|'-'
 // (can be joined by one white sapce).
 func parseRule(p *blockParser) stateFn {
-	r := p.next()
-	r1 := p.peek(2)
-	if r1 == ' ' {
-		for {
-			r1 = p.next()
-			if r1 != ' ' {
-				break
-			}
-			r1 = p.next()
-			if r1 != r {
-				break
-			}
-		}
-		p.emit(&Rule{})
-		return parseBegin
-	} else {
-		p.consume(r)
-		p.emit(&Rule{})
-		return parseBegin
-	}
+	p.skipLine()
+	p.emit(&Rule{})
+	return parseBegin
+}
 
+// quoteLine returns the content of line beginning with '>',
+// the '>' and one following column of space are removed.
+func quoteLine(line []byte) ([]byte, bool) {
+	if indentation(line) > 3 {
+		return nil, false
+	}
+	indent := indentation(line)
+	if indent == len(line) || line[indent] != '>' {
+		return nil, false
+	}
+	return containerLine(line[indent+1:], indent+1, 1), true
 }
 
-// parseQuote is the parser for state of quote.
+// parseQuote parses a quote as a container,
+// which holds lines beginning with '>' and lazy lines continuing a paragraph,
+// the '>' is removed and the content is parsed as blocks.
 func parseQuote(p *blockParser) stateFn {
 	var (
-		r       rune
-		content []byte
+		lines [][]byte
+		para  paragraphTracker
+		first = p.lineOf(p.cur)
 	)
-	for {
-		for r = p.next(); r != '\n' && r != eof; r = p.next() {
-		}
-
-		r1 := p.peek()
-		if r == '\n' && (r1 == '\n' || r1 == eof) {
+	for p.cur < len(p.src) {
+		line := p.line()
+		if content, ok := quoteLine(line); ok {
+			line = content
+		} else if isBlank(line) || !para.open || p.interrupt(line) {
+			// lazy continuation line only continues a paragraph.
 			break
 		}
-		if r == eof {
-			break
-		}
-	}
-	if r == '\n' {
-		content = p.src[p.start : p.cur-1]
-		p.next()
-	}
-	if r == eof {
-		content = p.src[p.start:p.cur]
-	}
-	lines := bytes.Split(content, []byte{'\n'})
-	for i := 0; i < len(lines); i++ {
-		// remove heading '>' and space.
-		if len(lines[i]) > 0 && lines[i][0] == '>' {
-			lines[i] = lines[i][1:]
-			if len(lines[i]) > 0 && lines[i][0] == ' ' {
-				lines[i] = lines[i][1:]
-			}
-		}
+		lines = append(lines, line)
+		para.add(line)
+		p.skipLine()
 	}
-	content = bytes.Join(lines, []byte{'\n'})
-	np := newParser(content)
-	quote := &QuoteBlock{}
+	quote := &QuoteBlock{level: p.depth + 1}
+	np := p.doc.newNestedParser(bytes.Join(lines, []byte{'\n'}), first, p.depth+1)
 	for b := np.element(); b != nil; b = np.element() {
 		quote.subBlocks = append(quote.subBlocks, b)
 	}
@@ -535,49 +965,48 @@ func parseError(p *blockParser) stateFn {
 
 // block main parsing.
 func parseBegin(p *blockParser) stateFn {
-	switch r := p.peek(); {
+	line := p.line()
+	if p.cur < len(p.src) && isBlank(line) {
+		p.blank = p.emitted
+		p.skipLine()
+		p.ignore()
+		return parseBegin
+	}
+	// blocks can be indented by no more than three spaces.
+	indent := indentation(line)
+	r := p.peek()
+	if indent < len(line) && indent <= 3 {
+		r, _ = utf8.DecodeRune(line[indent:])
+	}
+	switch {
+	case r == eof:
+		return nil
+	case indent > 3:
+		return parseCodeBlock
+	case isRule(line):
+		return parseRule
 	case r == '#':
-		return parseHead
-	case r == '-' || r == '*' || r == '+':
-		r1 := p.peek(2)
-		if r1 == ' ' {
-			// rule marker can be seperated by one white sapce.
-			if r == '*' || r == '-' {
-				r2 := p.peek(3)
-				if r2 == r && p.forsee(r, ' ', r, ' ', r) {
-					// fall to parseRule
-					return parseRule
-				}
-			}
-			if p.forsee(r, ' ', ' ', ' ') {
-				return parseUnorderList
-			}
+		if _, _, ok := atxHead(line, p.doc.commonMark()); ok {
+			return parseHead
 		}
-		if r1 == r && p.forsee(r, r, r) {
-			return parseRule
+		return parseParagraph
+	case r == '-' || r == '*' || r == '+' || unicode.IsDigit(r):
+		if _, ok := listItem(line); ok {
+			return parseList
 		}
 		return parseParagraph
-	case r == '_':
-		r1 := p.peek(2)
-		if r1 == r && (p.forsee(r, r, r) || p.forsee(r, ' ', r, ' ', r)) {
-			return parseRule
+	case r == '>':
+		return parseQuote
+	case r == '`' || r == '~':
+		if _, _, _, ok := fence(line); ok {
+			return parseFencedCode
 		}
 		return parseParagraph
-	case unicode.IsDigit(r):
-		if regexp.MustCompile(`\d+\.  `).Match(p.src[p.cur:]) {
-			return parseOrderList
+	case r == '<':
+		if htmlBlockStart(line) > 0 {
+			return parseHTMLBlock
 		}
 		return parseParagraph
-	case r == '>':
-		return parseQuote
-	case r == '\t' || (r == ' ' && p.forsee(' ', ' ', ' ')):
-		return parseCodeBlock
-	case r == '\n':
-		p.next()
-		p.ignore()
-		return parseBegin
-	case r == eof:
-		return nil
 	default:
 		return parseParagraph
 	}
@@ -585,135 +1014,307 @@ func parseBegin(p *blockParser) stateFn {
 
 // -----------span parsing----------
 
-// parse emphasis or strong span
-func parseEmphasis(p *spanParser) spanStateFn {
-	start := p.cur
-	marker := p.peek()
-	n := p.consume(marker, 2)
-	t := kind.Strong
-	if n == 1 {
-		t = kind.Emphasis
-	}
+// link is a link or image found in the source.
+type link struct {
+	image      bool
+	text, id   []byte
+	url, title []byte
+	refer      bool // full,collapsed or shortcut reference.
+	n          int  // length of the link in the source.
+}
 
-	for r := p.next(); r != marker && r != '\n' && r != eof; {
-		r = p.next()
+// findLink parses the link or image beginning src[i:],
+// which is an inline link,[text](url "title"),
+// or a reference to link reference definitions of the document,[text][id],[text][] and [text].
+// ok is false if the brackets do not make a link.
+func (p *spanParser) findLink(i int) (l link, ok bool) {
+	open := i
+	if p.src[i] == '!' {
+		if i+1 >= len(p.src) || p.src[i+1] != '[' {
+			return l, false
+		}
+		l.image = true
+		open++
 	}
-	r := p.peek()
-	f := string(marker)
-	if f == "*" {
-		f = "\\" + f
-	}
-	content := p.src[p.start:p.cur]
-	content = regexp.MustCompile("^"+f+"+").ReplaceAll(content, []byte{})
-	content = regexp.MustCompile(""+f+"+$").ReplaceAll(content, []byte{})
-	p.src = append(p.src[:p.start], p.src[p.cur:]...)
-	if r == marker && t == kind.Strong {
+	if p.brackets == nil {
+		p.brackets = findBrackets(p.src, p.cur)
+		p.bracketed = len(p.src)
+	}
+	// the source is cut before the current index only,
+	// which shifts the indexes of the brackets by the same offset.
+	offset := p.bracketed - len(p.src)
+	k := sort.Search(len(p.brackets), func(k int) bool { return p.brackets[k].open >= open+offset })
+	if k == len(p.brackets) || p.brackets[k].open != open+offset {
+		return l, false
+	}
+	// links can't contain other links.
+	b := p.brackets[k]
+	if b.close == -1 || !l.image && b.contains {
+		return l, false
+	}
+	end := b.close - offset
+	l.text = p.src[open+1 : end]
+	n := end + 1
+	if u, t, k, ok := inlineLink(p.src[n:]); ok {
+		l.url, l.title = u, t
+		n += k
+	} else {
+		l.refer = true
+		if label, k, ok := linkLabel(p.src[n:]); ok {
+			l.id = label
+			n += k
+		}
+		if p.doc.resolve(l.id, l.text) == nil {
+			return l, false
+		}
+	}
+	l.n = n - i
+	return l, true
+}
+
+// parseRef parses links and images,
+// the brackets are kept as text if they do not make a link.
+func parseRef(p *spanParser) spanStateFn {
+	l, ok := p.findLink(p.cur)
+	if !ok {
 		p.next()
-		p.emit(&Strong{start, content})
+		p.ignore()
+		return parseSpan
+	}
+	// copy as the source is cut below.
+	text, id, url, title := clone(l.text), clone(l.id), clone(l.url), clone(l.title)
+	p.src = append(p.src[:p.cur], p.src[p.cur+l.n:]...)
+	if l.image {
+		p.emit(&Image{p.cur, id, text, title, url, l.refer, p.doc})
 	} else {
-		p.emit(&Emphasis{start, content})
+		p.emit(&Link{start: p.cur, id: id, text: text, title: title, url: url, refer: l.refer, doc: p.doc})
 	}
 	return parseSpan
 }
 
-// cut content with left and right wrapped from the src.
-func cut(left, right byte, begin int, src []byte) (remain []byte, cut []byte) {
-	start := bytes.IndexByte(src[begin:], left)
-	end := start + 1 + bytes.IndexByte(src[begin+start+1:], right)
-	cut = make([]byte, end-start-1)
-	copy(cut, src[begin+start+1:begin+end])
-	remain = append(src[:begin+start], src[begin+end+1:]...)
-	return
+// clone returns a copy of b,which is nil if b is nil.
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
 }
 
-// parseRef parses links and images including references referring to the previous links or images.
-func parseRef(p *spanParser) spanStateFn {
+// bracket is '[' and its matching ']'.
+type bracket struct {
+	open, close int  // indexes of the brackets,close is -1 if '[' is not closed.
+	contains    bool // the text contains an inline link.
+}
+
+// findBrackets returns the brackets of src[i:] in the order of '[',
+// which are matched in one pass,
+// brackets escaped or in code spans,autolinks and raw html are skipped.
+func findBrackets(src []byte, i int) []bracket {
 	var (
-		text  []byte
-		title []byte
-		ref   []byte
-		id    []byte
-		k     kind.Kind
+		found = []bracket{}
+		stack []int // indexes of the brackets not closed yet.
 	)
-
-	r := p.peek()
-	if r == '[' {
-		k = kind.Link
-		i1 := bytes.IndexByte(p.src[p.cur:], ']')
-		i2 := bytes.IndexByte(p.src[p.cur:], ':')
-		// reference.
-		if i1+1 == i2 {
-			p.src, id = cut('[', ']', p.cur, p.src)
-			p.src, ref = cut(':', '\n', p.cur, p.src)
-			ref, title = cut('"', '"', 0, ref)
-			ref = bytes.TrimSpace(ref)
-			p.ref[string(id)] = &reference{ref, title}
-			return parseSpan
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '`':
+			// backticks not closed are text as a whole.
+			n := codeSpan(src[i:])
+			if n == 0 {
+				n = len(src[i:]) - len(trimLeft(src[i:], '`'))
+			}
+			i += n - 1
+		case '<':
+			if _, _, n := autolink(src[i:]); n > 0 {
+				i += n - 1
+			} else if n := rawHTML(src[i:]); n > 0 {
+				i += n - 1
+			}
+		case '[':
+			stack = append(stack, len(found))
+			found = append(found, bracket{open: i, close: -1})
+		case ']':
+			if len(stack) == 0 {
+				continue
+			}
+			b := &found[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			b.close = i
+			if len(stack) == 0 {
+				continue
+			}
+			// an inline link,but not an image,makes the enclosing text contain a link.
+			inline := false
+			if b.open == 0 || src[b.open-1] != '!' {
+				_, _, _, inline = inlineLink(src[i+1:])
+			}
+			if b.contains || inline {
+				found[stack[len(stack)-1]].contains = true
+			}
 		}
 	}
-	if r == '!' && p.peek(2) == '[' {
-		p.src = append(p.src[:p.cur], p.src[p.cur+1:]...)
-		k = kind.Image
-	}
-
-	p.src, text = cut('[', ']', p.cur, p.src)
-	r = p.peek()
+	return found
+}
 
-	if r == '[' {
-		p.src, id = cut('[', ']', p.cur, p.src)
+// codeSpan returns the length of code span beginning src,
+// which is enclosed in backtick strings of the same length,
+// returns 0 if the backtick string is not closed.
+func codeSpan(src []byte) int {
+	n := len(src) - len(bytes.TrimLeft(src, "`"))
+	for i := n; i < len(src); {
+		j := bytes.IndexByte(src[i:], '`')
+		if j == -1 {
+			return 0
+		}
+		i += j
+		m := len(src[i:]) - len(bytes.TrimLeft(src[i:], "`"))
+		if m == n {
+			return i + m
+		}
+		i += m
 	}
+	return 0
+}
 
-	if r == '(' {
-		p.src, ref = cut('(', ')', p.cur, p.src)
-		ref, title = cut('"', '"', 0, ref)
-		ref = bytes.TrimSpace(ref)
+// inlineLink parses the destination and title of inline link beginning src,
+// which is (url "title"),n is the length including the parentheses.
+func inlineLink(src []byte) (url, title []byte, n int, ok bool) {
+	if len(src) == 0 || src[0] != '(' {
+		return
 	}
-	if k == kind.Image {
-		p.emit(&Image{p.cur, id, text, title, ref})
+	i := skipSpaces(src, 1)
+	if i < len(src) && src[i] != ')' {
+		dest, k, ok := linkDestination(src[i:])
+		if !ok {
+			return nil, nil, 0, false
+		}
+		url = dest
+		i += k
+		if j := skipSpaces(src, i); j > i && j < len(src) && src[j] != ')' {
+			t, k, ok := linkTitle(src[j:])
+			if !ok {
+				return nil, nil, 0, false
+			}
+			title = t
+			i = j + k
+		}
+		i = skipSpaces(src, i)
 	}
-
-	if k == kind.Link {
-		p.emit(&Link{p.cur, id, text, title, ref})
+	if i >= len(src) || src[i] != ')' {
+		return nil, nil, 0, false
 	}
+	return unescape(url), unescape(title), i + 1, true
+}
 
-	return parseSpan
+// linkLabel returns the label of full or collapsed reference beginning src,
+// which is [label] or [],n is the length including the brackets.
+func linkLabel(src []byte) (label []byte, n int, ok bool) {
+	if len(src) == 0 || src[0] != '[' {
+		return
+	}
+	for n = 1; n < len(src) && n <= 1000; n++ {
+		switch src[n] {
+		case '\\':
+			n++
+		case '[':
+			return nil, 0, false
+		case ']':
+			return src[1:n], n + 1, true
+		}
+	}
+	return nil, 0, false
 }
 
-// parseCode parses span code.
+// parseCode parses code span enclosed in backtick strings of the same length,
+// line endings are turned into spaces,and one space is stripped from both sides
+// if the code begins and ends with spaces.
+// a backtick string which is not closed is text.
 func parseCode(p *spanParser) spanStateFn {
-	indexOfNewLine := bytes.IndexByte(p.src[p.cur:], '\n')
-	if indexOfNewLine == -1 {
-		indexOfNewLine = len(p.src[p.cur:])
+	run := len(p.src[p.cur:]) - len(trimLeft(p.src[p.cur:], '`'))
+	n := codeSpan(p.src[p.cur:])
+	if n == 0 {
+		p.cur += run
+		p.ignore()
+		return parseSpan
 	}
-	indexOfBacktick := bytes.LastIndex(p.src[p.cur:p.cur+indexOfNewLine], []byte{'`'})
-
-	var content = make([]byte, indexOfBacktick+1)
-	copy(content, p.src[p.cur:p.cur+indexOfBacktick+1])
-	content = content[1 : len(content)-1]
-	p.src = append(p.src[:p.cur], p.src[indexOfBacktick+1:]...)
+	content := bytes.ReplaceAll(p.src[p.cur+run:p.cur+n-run], []byte{'\n'}, []byte{' '})
+	if len(content) > 1 && content[0] == ' ' && content[len(content)-1] == ' ' && !isBlank(content) {
+		content = content[1 : len(content)-1]
+	}
+	p.src = append(p.src[:p.cur], p.src[p.cur+n:]...)
 	p.emit(&Code{p.cur, content})
 	return parseSpan
 }
 
-// isEscapeRune returns true if r needs escaping.
+// isEscapeRune returns true if r can be escaped,
+// which is any ascii punctuation character.
 func isEscapeRune(r rune) bool {
-	var escapeRunes = "\\'*_{}[]()#+-.!"
-	for _, v := range escapeRunes {
-		if rune(v) == r {
-			return true
+	return r < utf8.RuneSelf && isPunct(byte(r))
+}
+
+// spaces returns the number of heading spaces and tabs of src.
+func spaces(src []byte) int {
+	return len(src) - len(bytes.TrimLeft(src, " \t"))
+}
+
+// parseBreak parses line breaks,
+// a backslash or at least two spaces at the end of a line is a hard line break,
+// other spaces at the end of a line are removed.
+func parseBreak(p *spanParser) spanStateFn {
+	if p.peek() == '\\' {
+		p.src = append(p.src[:p.cur], p.src[p.cur+2:]...)
+		p.emit(&LineBreak{p.cur})
+		return parseSpan
+	}
+	n := spaces(p.src[p.cur:])
+	hard := p.cur+n < len(p.src) && bytes.Count(p.src[p.cur:p.cur+n], []byte{' '}) >= 2
+	if hard {
+		// the break replaces the new line.
+		n++
+	}
+	p.src = append(p.src[:p.cur], p.src[p.cur+n:]...)
+	if hard {
+		p.emit(&LineBreak{p.cur})
+	}
+	return parseSpan
+}
+
+// softBreak joins the lines at the new line as the options require.
+func (p *spanParser) softBreak() {
+	switch p.doc.opts.SoftBreak {
+	case SoftBreakSpace:
+		p.src[p.cur] = ' '
+	case SoftBreakSmart:
+		// the characters joined are text,but not delimiters of spans.
+		after, _ := utf8.DecodeRune(bytes.TrimLeft(p.src[p.cur+1:], "*_~=^`[!"))
+		if isCJK(p.lastRune()) && isCJK(after) {
+			p.src = append(p.src[:p.cur], p.src[p.cur+1:]...)
+			return
 		}
+		p.src[p.cur] = ' '
 	}
-	return false
+	p.next()
 }
 
-// find rune in the src,if not found,set positive infinite.
-func findRune(src []byte, r byte) int {
-	i := bytes.IndexByte(src, r)
-	if i == -1 {
-		i = 1 << 32 // big enough as eof
+// lastRune returns the last character of the text before the current index,
+// which is the last one of the span cut there if there is no text after it.
+func (p *spanParser) lastRune() rune {
+	if p.last != nil && p.lastAt == p.cur {
+		if r, n := utf8.DecodeLastRune(p.last.Content()); n > 0 {
+			return r
+		}
 	}
-	return i
+	r, _ := utf8.DecodeLastRune(p.src[:p.cur])
+	return r
+}
 
+// isCJK returns true if r is a Chinese or Japanese character or punctuation,
+// which are written without spaces between words.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		r >= 0x3000 && r <= 0x303f || // CJK symbols and punctuation
+		r >= 0xff00 && r <= 0xffef // halfwidth and fullwidth forms
 }
 
 // span main parsing.
@@ -722,27 +1323,56 @@ func parseSpan(p *spanParser) spanStateFn {
 		switch r := p.peek(); {
 		case r == '\\':
 			r1 := p.peek(2)
+			if r1 == '\n' {
+				return parseBreak
+			}
 			// merge escape rune into single rune.
 			// e.g. \* to *
 			if isEscapeRune(r1) {
-				p.next()
 				p.merge()
-				p.next()
 			}
-			return parseSpan
+			p.next()
+			p.ignore()
+		case r == '\n':
+			p.softBreak()
+			p.ignore()
+		case r == ' ' || r == '\t':
+			n := spaces(p.src[p.cur:])
+			if p.cur+n == len(p.src) || p.src[p.cur+n] == '\n' {
+				return parseBreak
+			}
+			p.cur += n
+			p.ignore()
 		case r == '`':
 			return parseCode
 		case r == '!' || r == '[':
 			return parseRef
-		case r == '*' || r == '_':
-			if findRune(p.src[p.cur+1:], byte(r)) < findRune(p.src[p.cur+1:], '\n') {
-				return parseEmphasis
+		case r == '&':
+			// replace the reference by the text it refers to.
+			if n, text := entity(p.src[p.cur:]); n > 0 {
+				p.src = append(p.src[:p.cur], append(text, p.src[p.cur+n:]...)...)
+				p.cur += len(text)
+			} else {
+				p.next()
+			}
+			p.ignore()
+		case r == '<':
+			if _, _, n := autolink(p.src[p.cur:]); n > 0 {
+				return parseAutolink
+			}
+			if rawHTML(p.src[p.cur:]) > 0 {
+				return parseInlineHTML
 			}
 			p.next()
 			p.ignore()
+		case r == '*' || r == '_' || r == '~' || r == '=' || r == '^':
+			return parseEmphasis
 		case r == eof:
 			return nil
 		default:
+			if p.doc.autolinkAt(p.src, p.cur) > 0 {
+				return parseAutolink
+			}
 			p.next()
 			p.ignore()
 		}
@@ -751,12 +1381,51 @@ func parseSpan(p *spanParser) spanStateFn {
 
 // Parse parses src with ext as extension,and returns pure text content.
 func Parse(src []byte, ext EXT) []byte {
-	if ext == BASIC {
+	return ParseWithOptions(src, DefaultOptions(ext))
+}
+
+// ParseWithOptions parses src as opts describe,and returns pure text content.
+func ParseWithOptions(src []byte, opts Options) []byte {
+	p := (&document{opts: opts}).newParser(normalize(src), 1)
+	// definitions can follow the links referring to them,
+	// so blocks are rendered after the whole source is parsed.
+	var blocks []Block
+	for block := p.element(); block != nil; block = p.element() {
+		blocks = append(blocks, block)
 	}
-	p := newParser(src)
 	var contents [][]byte
-	for block := p.element(); block != nil; block = p.element() {
-		contents = append(contents, block.Content())
+	for _, block := range blocks {
+		content := block.Content()
+		// blocks without text,e.g. comments,leave no lines.
+		if len(content) == 0 {
+			continue
+		}
+		contents = append(contents, content)
+	}
+	text := bytes.Join(contents, []byte("\n"))
+	if opts.LineEnding == CRLF {
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r\n"))
+	}
+	return text
+}
+
+// normalize replaces CRLF and lone CR by LF and drops a leading BOM,
+// every line keeps its line number.
+func normalize(src []byte) []byte {
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	if bytes.IndexByte(src, '\r') == -1 {
+		return src
+	}
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\r' {
+			out = append(out, src[i])
+			continue
+		}
+		out = append(out, '\n')
+		if i+1 < len(src) && src[i+1] == '\n' {
+			i++
+		}
 	}
-	return bytes.Join(contents, []byte("\n"))
+	return out
 }
@@ -1,7 +1,9 @@
 package md2txt
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/zouhuigang/md2txt/kind"
 )
@@ -18,6 +20,74 @@ func TestHead(t *testing.T) {
 	}
 }
 
+func TestATXHead(t *testing.T) {
+	d := &document{opts: DefaultOptions(CommonMark)}
+	p := d.newParser([]byte("#头部\n   ### 三级 ###   \n####### 七级\n## 二级 #\\##\n#\n"), 1)
+	var want = []struct {
+		k       kind.Kind
+		level   int
+		content string
+	}{
+		{kind.Paragraph, 0, "#头部"},
+		{kind.Head, 3, "三级"},
+		{kind.Paragraph, 0, "####### 七级"},
+		{kind.Head, 2, "二级 ###"},
+		{kind.Head, 1, ""},
+	}
+	for _, w := range want {
+		e := p.element()
+		if e.Type() != w.k || string(e.Content()) != w.content {
+			t.Logf("%s %s", e.Type(), e.Content())
+			t.Fail()
+		}
+		if h, ok := e.(*Head); ok && h.level != w.level {
+			t.Logf("%d", h.level)
+			t.Fail()
+		}
+	}
+	// BASIC allows no space and closing sequence without space.
+	if e := newParser([]byte("#Head#")).element(); e.Type() != kind.Head || string(e.Content()) != "Head" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+}
+
+func TestHeadSpans(t *testing.T) {
+	src := []byte("## **Install** the [CLI](/cli) &amp; `go get`\n\n*Setext* _head_\n---\n\n* use `go get`\n> quoted *text*")
+	got := string(Parse(src, CommonMark))
+	if got != "Install the CLI & go get\nSetext head\nuse go get\nquoted text" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+}
+
+func TestSetextHead(t *testing.T) {
+	p := newParser([]byte("Foo\n  bar\n---\n\nbaz\n- foo\n***\n=\n"))
+	e := p.element()
+	if e.Type() != kind.Head || string(e.Content()) != "Foo\nbar" || e.(*Head).level != 2 {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	// not an underline.
+	e = p.element()
+	if e.Type() != kind.Paragraph || string(e.Content()) != "baz" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	if e = p.element(); e.Type() != kind.List || string(e.Content()) != "foo" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	if e = p.element(); e.Type() != kind.Rule {
+		t.Fail()
+	}
+	// underline without paragraph.
+	if e = p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "=" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+}
+
 func TestParagraphHead(t *testing.T) {
 	p := newParser([]byte("一级头部\n======\n"))
 	e := p.element()
@@ -82,11 +152,48 @@ quote1` {
 	}
 }
 
+func TestQuoteContainer(t *testing.T) {
+	p := newParser([]byte(`> On Monday, Bob wrote:
+> > first line
+lazy line
+> >
+> >     code
+---
+- > in item
+  lazy in item
+`))
+	q := p.element().(*QuoteBlock)
+	if q.Depth() != 1 || len(q.Blocks()) != 2 {
+		t.Fatalf("%d %s", q.Depth(), q.Content())
+	}
+	nested := q.Blocks()[1].(*QuoteBlock)
+	if nested.Depth() != 2 || string(nested.Blocks()[0].Content()) != "first line\nlazy line" {
+		t.Logf("%d %s", nested.Depth(), nested.Content())
+		t.Fail()
+	}
+	if c := nested.Blocks()[1]; c.Type() != kind.CodeBlock || string(c.Content()) != "code" {
+		t.Logf("%s", c.Content())
+		t.Fail()
+	}
+	// quote ends without a blank line.
+	if e := p.element(); e.Type() != kind.Rule {
+		t.Logf("%s", e.Type())
+		t.Fail()
+	}
+	l := p.element().(*List)
+	q = l.Items()[0].Blocks()[0].(*QuoteBlock)
+	if q.Depth() != 1 || string(q.Content()) != "in item\nlazy in item" {
+		t.Logf("%d %s", q.Depth(), q.Content())
+		t.Fail()
+	}
+}
+
 func TestItemSubBlocks(t *testing.T) {
-	bs1, n := parseItemBlocks([]byte(`    subBlocks
+	p := newParser([]byte(`-   subBlocks
 in lazy mode
 `))
-	for _, b := range bs1 {
+	l := p.element().(*List)
+	for _, b := range l.items[0].subBlocks {
 		if string(b.Content()) != `subBlocks
 in lazy mode` {
 			t.Logf("%s", b.Content())
@@ -95,28 +202,60 @@ in lazy mode` {
 		if b.Type() != kind.Paragraph {
 			t.Fail()
 		}
-		if n != 27 {
-			t.Logf("%d", n)
-			t.Fail()
-		}
 	}
 
-	bs2, n := parseItemBlocks([]byte(`    > subBlocks
+	p = newParser([]byte(`-   > subBlocks
 	> with heading indents
 
+    paragraph
 `))
-	for _, b := range bs2 {
-		if string(b.Content()) != `subBlocks
-with heading indents` {
-			t.Fail()
-		}
-		if b.Type() != kind.QuoteBlock {
-			t.Fail()
-		}
-		if n != 41 {
-			t.Logf("%d", n)
-			t.Fail()
-		}
+	l = p.element().(*List)
+	bs := l.items[0].subBlocks
+	if len(bs) != 2 {
+		t.Fatalf("%d", len(bs))
+	}
+	if string(bs[0].Content()) != `subBlocks
+with heading indents` || bs[0].Type() != kind.QuoteBlock {
+		t.Logf("%s", bs[0].Content())
+		t.Fail()
+	}
+	if string(bs[1].Content()) != `paragraph` || bs[1].Type() != kind.Paragraph {
+		t.Logf("%s", bs[1].Content())
+		t.Fail()
+	}
+}
+
+func TestNestedList(t *testing.T) {
+	p := newParser([]byte(`- a
+  - b
+lazy b
+    1. c
+
+       code follows
+
+           code
+  - d
+- e`))
+	l := p.element().(*List)
+	if len(l.items) != 2 {
+		t.Fatalf("%d", len(l.items))
+	}
+	sub := l.items[0].subBlocks[1].(*List)
+	if len(sub.items) != 2 || string(sub.items[0].subBlocks[0].Content()) != "b\nlazy b" {
+		t.Logf("%s", sub.Content())
+		t.Fail()
+	}
+	subsub := sub.items[0].subBlocks[1].(*List)
+	if !subsub.Ordered() || len(subsub.items[0].subBlocks) != 3 {
+		t.Fatalf("%s", subsub.Content())
+	}
+	if c := subsub.items[0].subBlocks[2]; c.Type() != kind.CodeBlock || string(c.Content()) != "code" {
+		t.Logf("%s", c.Content())
+		t.Fail()
+	}
+	if string(l.Content()) != "a\nb\nlazy b\nc\ncode follows\ncode\nd\ne" {
+		t.Logf("%s", l.Content())
+		t.Fail()
 	}
 }
 
@@ -217,14 +356,51 @@ item3` {
 		t.Fail()
 	}
 
-	if string(e2.Content()) != `* item
-* item
-* item` {
+	if string(e2.Content()) != `item
+item
+item` {
 		t.Logf("%s", e2.Content())
 		t.Fail()
 	}
 }
 
+func TestListMarker(t *testing.T) {
+	p := newParser([]byte("- a\n- b\n+ c\n\n3) d\n10) e\n7. f\n"))
+	var want = []struct {
+		content string
+		ordered bool
+		bullet  byte
+		start   int
+	}{
+		{"a\nb", false, '-', 0},
+		{"c", false, '+', 0},
+		{"d\ne", true, ')', 3},
+		{"f", true, '.', 7},
+	}
+	for _, w := range want {
+		e := p.element()
+		l, ok := e.(*List)
+		if !ok {
+			t.Fatalf("%s %s", e.Type(), e.Content())
+		}
+		if string(l.Content()) != w.content || l.Ordered() != w.ordered || l.Bullet() != w.bullet || l.Start() != w.start {
+			t.Logf("%s %v %c %d", l.Content(), l.Ordered(), l.Bullet(), l.Start())
+			t.Fail()
+		}
+	}
+
+	// only items which are not empty and begin with 1 interrupt a paragraph.
+	p = newParser([]byte("text\n2. two\ntext\n1. one\n"))
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "text\n2. two\ntext" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	if e := p.element(); e.Type() != kind.List || string(e.Content()) != "one" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+}
+
 func TestListWithSubItems(t *testing.T) {
 	p := newParser([]byte(`1.  This is a list item with two paragraphs. Lorem ipsum dolor
     sit amet, consectetuer adipiscing elit. Aliquam hendrerit
@@ -242,13 +418,43 @@ mi posuere lectus.
 Vestibulum enim wisi, viverra nec, fringilla in, laoreet
 vitae, risus. Donec sit amet nisl. Aliquam semper ipsum
 sit amet velit.
+
 Suspendisse id sem consectetuer libero luctus adipiscing.` {
 		t.Logf("%s", e1.Content())
 		t.Fail()
 
 	}
+	if e1.(*List).Tight() {
+		t.Fail()
+	}
 
 }
+func TestTightList(t *testing.T) {
+	var want = []struct {
+		src     string
+		tight   bool
+		content string
+	}{
+		{"- a\n- b\n", true, "a\nb"},
+		{"- a\n\n- b\n", false, "a\n\nb"},
+		{"- a\n\n  c\n- b\n", false, "a\nc\n\nb"},
+		// blank lines in nested list or code don't loosen the list.
+		{"- a\n  - c\n\n  - d\n- b\n\n", true, "a\nc\n\nd\nb"},
+		{"- ```\n  a\n\n  ```\n- b\n", true, "a\n\nb"},
+	}
+	for _, w := range want {
+		l := newParser([]byte(w.src)).element().(*List)
+		if l.Tight() != w.tight || string(l.Content()) != w.content {
+			t.Logf("%q %v %q", w.src, l.Tight(), l.Content())
+			t.Fail()
+		}
+	}
+	l := newParser([]byte("- a\n\n  c\n")).element().(*List)
+	if bs := l.Items()[0].Blocks(); len(bs) != 2 || string(bs[1].Content()) != "c" {
+		t.Fail()
+	}
+}
+
 func TestCodeBlock(t *testing.T) {
 	p := newParser([]byte(`	codeblock1`))
 	e := p.element()
@@ -258,6 +464,288 @@ func TestCodeBlock(t *testing.T) {
 	}
 }
 
+func TestIndentedCodeBlock(t *testing.T) {
+	p := newParser([]byte("    func main() {\n        fmt.Println()\n      \n\n    }\n    \n\ntext"))
+	e := p.element()
+	if e.Type() != kind.CodeBlock || string(e.Content()) != "func main() {\n    fmt.Println()\n  \n\n}" {
+		t.Logf("%q", e.Content())
+		t.Fail()
+	}
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "text" {
+		t.Logf("%s %q", e.Type(), e.Content())
+		t.Fail()
+	}
+}
+
+func TestFencedCodeBlock(t *testing.T) {
+	p := newParser([]byte("para\n  ```go main\n  func main() {\n  \tfmt.Println(\"`x`\")\n   }\n  ```\n~~~~\n~~~\ncode"))
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "para" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	e := p.element().(*CodeBlock)
+	if string(e.Content()) != "func main() {\n\tfmt.Println(\"`x`\")\n }" {
+		t.Logf("'%s'", e.Content())
+		t.Fail()
+	}
+	if e.Lang() != "go" || e.Info() != "go main" || !e.Fenced() {
+		t.Logf("%s %s", e.Lang(), e.Info())
+		t.Fail()
+	}
+	// unclosed fence runs to the end.
+	e = p.element().(*CodeBlock)
+	if string(e.Content()) != "~~~\ncode" || e.Lang() != "" {
+		t.Logf("'%s'", e.Content())
+		t.Fail()
+	}
+}
+
+func TestExtractCode(t *testing.T) {
+	snippets := ExtractCode([]byte("# Tutorial\n\n```go file=main.go\npackage main\n```\n\n> ~~~ sh\n> go run .\n> ~~~\n\n\tindented\n"))
+	if len(snippets) != 3 {
+		t.Fatalf("%v", snippets)
+	}
+	s := snippets[0]
+	if s.Lang != "go" || s.Info != "go file=main.go" || s.Text != "package main" || s.Pos != 3 || s.File() != "main.go" {
+		t.Logf("%+v", s)
+		t.Fail()
+	}
+	s = snippets[1]
+	if s.Lang != "sh" || s.Text != "go run ." || s.Pos != 7 || s.File() != "" {
+		t.Logf("%+v", s)
+		t.Fail()
+	}
+	s = snippets[2]
+	if s.Lang != "" || s.Text != "indented" || s.Pos != 11 {
+		t.Logf("%+v", s)
+		t.Fail()
+	}
+	if f := (CodeSnippet{Info: `go file="a b.go"`}).File(); f != "a b.go" {
+		t.Logf("%s", f)
+		t.Fail()
+	}
+}
+
+func TestHTMLBlock(t *testing.T) {
+	p := newParser([]byte(`text
+<details>
+<summary>Click</summary>
+<p>hidden<br>text</p>
+<ul><li>one</li><li>two</li></ul>
+</details>
+
+<script type="text/javascript">
+var a = "<p>";
+
+</script>
+<!-- comment
+-->
+<span class="x">
+inline
+</span>
+`))
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "text" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	e := p.element().(*BlockHtml)
+	if string(e.Content()) != "Click\nhidden\ntext\none\ntwo" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	if !strings.HasPrefix(string(e.HTML()), "<details>") {
+		t.Logf("%s", e.HTML())
+		t.Fail()
+	}
+	for _, want := range []string{"", "", "inline"} {
+		e := p.element()
+		if e.Type() != kind.HTMLBlock || string(e.Content()) != want {
+			t.Logf("%s %q", e.Type(), e.Content())
+			t.Fail()
+		}
+	}
+}
+
+func TestInlineHTML(t *testing.T) {
+	src := []byte(`press <kbd>Ctrl</kbd><!-- key -->,see <a href="/doc" title="T">doc</a><br>
+<img src="a.png" alt="logo"> a<b`)
+	sp := newSpanParser(append([]byte(nil), src...))
+	var tags []string
+	for s := sp.element(); s != nil; s = sp.element() {
+		if s.Type() != kind.InlineHTML {
+			t.Logf("%s", s.Type())
+			t.Fail()
+		}
+		tags = append(tags, string(s.(*InlineHTML).HTML()))
+	}
+	if len(tags) != 7 || tags[2] != "<!-- key -->" || tags[6] != `<img src="a.png" alt="logo">` {
+		t.Logf("%q", tags)
+		t.Fail()
+	}
+
+	for _, v := range []struct {
+		opts Options
+		want string
+	}{
+		{DefaultOptions(BASIC), "press Ctrl,see docT/doc\nlogoa.png a<b"},
+		{DefaultOptions(CommonMark), "press Ctrl,see doc\nlogo a<b"},
+		{Options{Ext: CommonMark, LinkURL: true}, "press Ctrl,see doc/doc\na.png a<b"},
+	} {
+		if got := string(ParseWithOptions(src, v.opts)); got != v.want {
+			t.Logf("%q", got)
+			t.Fail()
+		}
+	}
+}
+
+func TestEntity(t *testing.T) {
+	for _, v := range []struct{ src, want string }{
+		{"&amp; &copy; &#35; &#x4E2D; &#0; &ngE;", "& © # 中 \uFFFD ≧̸"},
+		{"&nosuch; &amp &#87654321; &x;", "&nosuch; &amp &#87654321; &x;"},
+		{"&notit; &notin; &semi; &#xD800; &#x110000; &#X41;", "&notit; ∉ ; \uFFFD \uFFFD A"},
+		{"`&amp;` &amp;", "&amp; &"},
+		{`[&copy;](/a "&quot;t&quot;") ![&lt;](/i "&amp;")`, `©"t"/a <&/i`},
+		{"<p>&lt;p&gt; <img alt=\"&amp;\"></p>", "<p> &"},
+		{"    &amp;", "&amp;"},
+	} {
+		if got := string(Parse([]byte(v.src), BASIC)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestDirective(t *testing.T) {
+	src := []byte(`# Title
+<!-- md2txt:skip-next -->
+[![badge](/b.svg "b")](/ci)
+
+text <!-- note --> here
+<!--
+comment
+-->
+
+<!-- md2txt:off -->
+* generated
+* toc
+<!-- md2txt:on -->
+> quote
+> <!-- md2txt:off -->
+>
+> hidden
+
+footer`)
+	got := string(Parse(src, CommonMark))
+	if got != "Title\ntext here\nquote\nfooter" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	// comments in containers leave no lines either.
+	for _, v := range []struct{ src, want string }{
+		{"- a\n  <!-- c -->\n- b", "a\nb"},
+		{"> a\n> <!-- c -->\n> b", "a\nb"},
+	} {
+		if got := string(Parse([]byte(v.src), CommonMark)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	src := []byte("\xef\xbb\xbfTitle\r\n=====\r\n\r\n* one\r* two\r\n\r\n```go\r\ncode\r\n```\r\n")
+	if got := string(Parse(src, CommonMark)); got != "Title\none\ntwo\ncode" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	opts := DefaultOptions(CommonMark)
+	opts.LineEnding = CRLF
+	if got := string(ParseWithOptions(src, opts)); got != "Title\r\none\r\ntwo\r\ncode" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	if s := ExtractCode(src); len(s) != 1 || s[0].Text != "code" || s[0].Pos != 7 {
+		t.Logf("%+v", s)
+		t.Fail()
+	}
+}
+
+func TestTabStop(t *testing.T) {
+	for _, v := range []struct{ src, want string }{
+		{"  \tfoo\tbar", "foo\tbar"},
+		{">\t\tfoo", "  foo"},
+		{"- foo\n\n\t\tbar", "foo\n  bar"},
+		{"-\tfoo\n\tbar", "foo\nbar"},
+		{"* a\n  \t* b", "a\nb"},
+	} {
+		if got := string(Parse([]byte(v.src), CommonMark)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+	if n := indentation([]byte(" \t  x")); n != 6 {
+		t.Logf("%d", n)
+		t.Fail()
+	}
+}
+
+func TestLineBreak(t *testing.T) {
+	sp := newSpanParser([]byte("foo  \nbar\\\nbaz \nqux\\a  "))
+	for _, want := range []int{3, 6} {
+		s := sp.element()
+		if s == nil || s.Type() != kind.LineBreak || s.StartPos() != want || string(s.Content()) != "\n" {
+			t.Logf("%v", s)
+			t.Fail()
+		}
+	}
+	if s := sp.element(); s != nil {
+		t.Logf("%v", s)
+		t.Fail()
+	}
+	if string(sp.src) != "foobarbaz\nqux\\a" {
+		t.Logf("%q", sp.src)
+		t.Fail()
+	}
+	if got := string(Parse([]byte("foo  \nbar\\\nbaz \nqux\\"), CommonMark)); got != "foo\nbar\nbaz\nqux\\" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+}
+
+func TestSoftBreak(t *testing.T) {
+	src := []byte("hello\nworld  \nline\n中文\n段落，\n换行 \nend")
+	for _, v := range []struct {
+		mode SoftBreak
+		want string
+	}{
+		{SoftBreakPreserve, "hello\nworld\nline\n中文\n段落，\n换行\nend"},
+		{SoftBreakSpace, "hello world\nline 中文 段落， 换行 end"},
+		{SoftBreakSmart, "hello world\nline 中文段落，换行 end"},
+	} {
+		opts := DefaultOptions(CommonMark)
+		opts.SoftBreak = v.mode
+		if got := string(ParseWithOptions(src, opts)); got != v.want {
+			t.Logf("%d: %q", v.mode, got)
+			t.Fail()
+		}
+	}
+
+	// markup at the ends of the lines is not the text joined.
+	opts := DefaultOptions(CommonMark)
+	opts.SoftBreak = SoftBreakSmart
+	for _, v := range []struct{ src, want string }{
+		{"**中文**\n段落", "中文段落"},
+		{"中文\n**段落**", "中文段落"},
+		{"`中文`\n[段落](/u)", "中文段落"},
+		{"*a*\n段落 中文\n*b*", "a 段落 中文 b"},
+	} {
+		if got := string(ParseWithOptions([]byte(v.src), opts)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+}
+
 func TestHorizontalRules(t *testing.T) {
 	p := newParser([]byte(`***`))
 	e := p.element()
@@ -311,6 +799,62 @@ func TestEmphasis(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestEmphasisNesting(t *testing.T) {
+	for _, v := range []struct{ src, want string }{
+		{"***both***", "both"},
+		{"**bold _and italic_**", "bold and italic"},
+		{"*across\nlines*", "across\nlines"},
+		{"snake_case_names and 2*3*4", "snake_case_names and 234"},
+		{"**foo*", "*foo"},
+		{"*foo**", "foo*"},
+		{"*a**b*", "a**b"},
+		{"*[foo*](/uri)", "*foo*"},
+		{"_ not_ *em\\*", "_ not_ *em*"},
+		{"中文*强调*文字", "中文强调文字"},
+		{"a *b* c\\", "a b c\\"},
+	} {
+		if got := string(Parse([]byte(v.src), CommonMark)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+
+	sp := newSpanParser([]byte("a ***b** c*"))
+	e, ok := sp.element().(*Emphasis)
+	if !ok || e.StartPos() != 2 || string(e.Content()) != "b c" {
+		t.Logf("%v", e)
+		t.FailNow()
+	}
+	spans := e.Spans()
+	if len(spans) != 1 || spans[0].Type() != kind.Strong || spans[0].StartPos() != 0 || string(spans[0].Content()) != "b" {
+		t.Logf("%v", spans)
+		t.Fail()
+	}
+}
+
+// delimiters are matched in one pass,
+// so that long runs of them do not take quadratic time.
+func TestEmphasisLinear(t *testing.T) {
+	for _, v := range []struct {
+		unit string
+		ext  EXT
+	}{
+		{"*a ", CommonMark},
+		{"_a_ ", CommonMark},
+		{"**a* ", CommonMark},
+		{"~~a ", GFM},
+		{"^a ~a ==a ", ExtSuperscript | ExtSubscript | ExtHighlight},
+	} {
+		src := []byte(strings.Repeat(v.unit, 32<<10/len(v.unit)))
+		start := time.Now()
+		Parse(src, v.ext)
+		if d := time.Since(start); d > 2*time.Second {
+			t.Logf("%q: %v", v.unit, d)
+			t.Fail()
+		}
+	}
+}
 func TestCode(t *testing.T) {
 	sp := newSpanParser([]byte("It is `code`"))
 	s := sp.element()
@@ -323,6 +867,21 @@ func TestCode(t *testing.T) {
 	}
 }
 
+func TestCodeSpan(t *testing.T) {
+	for _, v := range []struct{ src, want string }{
+		{"``a ` b`` and `c` `d`", "a ` b and c d"},
+		{"` `` `", "``"},
+		{"`  ` `\nfoo\n`", "   foo"},
+		{"`\\*` ```unclosed`", "\\* ```unclosed`"},
+		{"\\` \\> \\| \\~ \\\" \\< \\= \\a", "` > | ~ \" < = \\a"},
+	} {
+		if got := string(Parse([]byte(v.src), CommonMark)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+}
+
 func TestLink(t *testing.T) {
 	sp := newSpanParser([]byte("It is [link](ref \"title\")"))
 	s := sp.element()
@@ -339,6 +898,22 @@ func TestLink(t *testing.T) {
 	}
 }
 
+// brackets are matched in one pass,
+// so that nested brackets do not take cubic time.
+func TestLinkLinear(t *testing.T) {
+	for _, src := range []string{
+		strings.Repeat("[a", 8<<10) + strings.Repeat("]", 8<<10),
+		strings.Repeat("[a [b](c) ", 4<<10) + strings.Repeat("]", 4<<10),
+		strings.Repeat("[a][", 8<<10),
+	} {
+		start := time.Now()
+		Parse([]byte(src), CommonMark)
+		if d := time.Since(start); d > 2*time.Second {
+			t.Logf("%q: %v", src[:8], d)
+			t.Fail()
+		}
+	}
+}
 func TestImage(t *testing.T) {
 	sp := newSpanParser([]byte("It is ![image](ref \"title\")"))
 	s := sp.element()
@@ -356,16 +931,206 @@ func TestImage(t *testing.T) {
 
 }
 
+func TestInlineLink(t *testing.T) {
+	for _, v := range []struct{ src, want string }{
+		{"[a [nested] link](/url) after", "a [nested] link after"},
+		{"[Go](https://en.wikipedia.org/wiki/Go_(language)).", "Go."},
+		{`[a \] b](</my url> 't') [x\](y)`, "a ] b [x](y)"},
+		{"[![badge](/img.svg)](/ci) text", "badge text"},
+		{"[foo [bar](/uri)](/uri2)", "[foo bar](/uri2)"},
+		{"[*em*](/u) [unclosed (a) ![no", "em [unclosed (a) ![no"},
+		{"[link](/u \"t\" x) !bang", "[link](/u \"t\" x) !bang"},
+	} {
+		if got := string(Parse([]byte(v.src), CommonMark)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+	if got := string(Parse([]byte("[![b](/i \"t\")](/u)"), BASIC)); got != "bt/i/u" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	// extensions without CommonMark keep the title and url as BASIC.
+	if got := string(Parse([]byte("[t](/u \"T\")"), ExtTable)); got != "tT/u" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+}
+func TestTable(t *testing.T) {
+	src := "intro\n| 名前 | Age | *Note* |\n|:--|--:|:-:|\n| 山田 | 3 | `a\\|b` |\n| Bob | 42\nlast | row | x | extra\n\nafter"
+	p := (&document{opts: DefaultOptions(GFM)}).newParser([]byte(src), 1)
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "intro" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	e := p.element()
+	table, ok := e.(*Table)
+	if !ok {
+		t.Fatalf("%v", e.Type())
+	}
+	if a := table.Align(); len(a) != 3 || a[0] != AlignLeft || a[1] != AlignRight || a[2] != AlignCenter {
+		t.Logf("%v", a)
+		t.Fail()
+	}
+	if h := table.Header(); len(h) != 3 || string(h[0]) != "名前" || string(h[2]) != "Note" {
+		t.Logf("%q", h)
+		t.Fail()
+	}
+	if rows := table.Rows(); len(rows) != 3 || string(rows[0][2]) != "a|b" || len(rows[1][2]) != 0 || len(rows[2]) != 3 {
+		t.Logf("%q", rows)
+		t.Fail()
+	}
+	if s := table.Spans(0, 2); len(s) != 1 || s[0].Type() != kind.Emphasis {
+		t.Fail()
+	}
+	want := "名前  Age  Note\n山田    3  a|b\nBob    42\nlast  row   x"
+	if got := string(table.Content()); got != want {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	if e := p.element(); string(e.Content()) != "after" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+
+	opts := DefaultOptions(GFM)
+	opts.TableStyle = TableCellPerLine
+	for _, v := range []struct {
+		src, want string
+		opts      Options
+	}{
+		{"a|b\n-|-\n1|\n2|3", "a\nb\n\n1\n\n2\n3", opts},
+		{"| a |\n| - |\n| b |", "a\nb", DefaultOptions(GFM)},
+		{"| x<br>y | z |\n| - | - |\n| 1 | 2 |", "x y  z\n1    2", DefaultOptions(GFM)},
+		{"| a | b |\n| - |", "| a | b |\n| - |", DefaultOptions(GFM)},
+		{"a\n---", "a", DefaultOptions(GFM)},
+		{"| a |\n| - |", "| a |\n| - |", DefaultOptions(CommonMark)},
+	} {
+		if got := string(ParseWithOptions([]byte(v.src), v.opts)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+}
+func TestDelimited(t *testing.T) {
+	all := GFM | ExtHighlight | ExtSuperscript | ExtSubscript
+	src := "~~del~~ ==hi== x^2^ H~2~O a == b 2^10 and 3^4 `~~c~~` ~~~x~~~ [~~l~~](/u)"
+	for _, v := range []struct {
+		ext  EXT
+		want string
+	}{
+		{CommonMark, "~~del~~ ==hi== x^2^ H~2~O a == b 2^10 and 3^4 ~~c~~ ~~~x~~~ ~~l~~"},
+		{GFM, "del ==hi== x^2^ H2O a == b 2^10 and 3^4 ~~c~~ ~~~x~~~ l"},
+		{all, "del hi x2 H2O a == b 2^10 and 3^4 ~~c~~ ~~~x~~~ l"},
+	} {
+		if got := string(Parse([]byte(src), v.ext)); got != v.want {
+			t.Logf("%v: %q", v.ext, got)
+			t.Fail()
+		}
+	}
+
+	// closers match the nearest opener,and delimiters between are text.
+	for _, v := range []struct{ src, want string }{
+		{"~~a ~~b~~", "~~a b"},
+		{"^a b^c^ ~~x *y~~ z*", "^a bc x *y z*"},
+	} {
+		if got := string(Parse([]byte(v.src), all)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+
+	opts := DefaultOptions(all)
+	opts.Strikethrough, opts.Superscript = SpanDrop, SpanMarked
+	if got := string(ParseWithOptions([]byte("a ~~b *c*~~ d^*e*^ ~f~"), opts)); got != "a  d^e^ f" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+	_, spans := (&document{opts: opts}).spans([]byte("~~a *b*~~ ==c== x^2^ ~d~"))
+	kinds := []kind.Kind{kind.Strikethrough, kind.Highlight, kind.Superscript, kind.Subscript}
+	if len(spans) != len(kinds) {
+		t.Fatalf("%d spans", len(spans))
+	}
+	for i, s := range spans {
+		if s.Type() != kinds[i] {
+			t.Logf("%v", s.Type())
+			t.Fail()
+		}
+	}
+	if s := spans[0].(*Strikethrough).Spans(); len(s) != 1 || s[0].Type() != kind.Emphasis {
+		t.Fail()
+	}
+}
+func TestAutolink(t *testing.T) {
+	for _, v := range []struct {
+		src, want string
+		ext       EXT
+	}{
+		{"<https://example.com/a?b> <me@example.com>", "https://example.com/a?b me@example.com", CommonMark},
+		{"<https://example.com>", "https://example.com", BASIC},
+		{"www.example.com is bare", "www.example.com is bare", CommonMark},
+		{"see www.commonmark.org/a.b. and (https://x.org/y(z)).", "see www.commonmark.org/a.b. and (https://x.org/y(z)).", GFM},
+		{"*https://a.org/*_b_* www.a_b.c_d ~foo@bar.baz~", "https://a.org/*_b_ www.a_b.c_d foo@bar.baz", GFM},
+	} {
+		if got := string(Parse([]byte(v.src), v.ext)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
+
+	for _, v := range []struct{ src, url string }{
+		{"<me@example.com>", "mailto:me@example.com"},
+		{"www.commonmark.org/a.b.", "http://www.commonmark.org/a.b"},
+		{"(https://x.org/y(z))", "https://x.org/y(z)"},
+		{"https://x.org/?q=a&hl;", "https://x.org/?q=a"},
+		{"foo.bar-baz@qux.org.", "mailto:foo.bar-baz@qux.org"},
+	} {
+		var url string
+		_, spans := (&document{opts: DefaultOptions(GFM)}).spans([]byte(v.src))
+		for _, s := range spans {
+			if l, ok := s.(*Link); ok {
+				url = string(l.URL())
+			}
+		}
+		if url != v.url {
+			t.Logf("%q: %q", v.src, url)
+			t.Fail()
+		}
+	}
+	// urls are not repeated in basic markdown.
+	if got := string(Parse([]byte("[a](/u) <http://b.c>"), BASIC)); got != "a/u http://b.c" {
+		t.Logf("%q", got)
+		t.Fail()
+	}
+}
 func TestReference(t *testing.T) {
-	sp := newSpanParser([]byte("[id]: link \"title\"\n"))
-	sp.element()
-	ref := sp.ref["id"]
-	if string(ref.link) != "link" {
+	p := newParser([]byte("[id]: link \"title\"\n[Other  ID]:\n<my url> 'multi\nline'\ntext"))
+	if e := p.element(); e.Type() != kind.Paragraph || string(e.Content()) != "text" {
+		t.Logf("%s", e.Content())
+		t.Fail()
+	}
+	ref := p.doc.lookup([]byte("ID"))
+	if ref == nil || string(ref.link) != "link" || string(ref.title) != "title" {
 		t.Fail()
 	}
-	if string(ref.title) != "title" {
+	ref = p.doc.lookup([]byte("other id"))
+	if ref == nil || string(ref.link) != "my url" || string(ref.title) != "multi\nline" {
 		t.Fail()
 	}
+
+	for _, v := range []struct{ src, want string }{
+		{"[foo] [bar][Id] ![img][]\n\n[id]: /url\n[img]: /i.png (t)\n[foo]: /first\n[foo]: /second", "foo/first bar/url imgt/i.png"},
+		{"[none] [a][none]", "[none] [a][none]"},
+		{"[ẞ] [ﬁle] [Σσς]\n\n[SS]: /ss\n[FILE]: /f\n[σΣΣ]: /s", "ẞ/ss ﬁle/f Σσς/s"},
+		{"[foo]: /url \"title\" ok\n\n[foo]", "[foo]: /url \"title\" ok\n[foo]"},
+		{"[foo]: /url\n===", "==="},
+		{"> [q]: /q\n\n- [q]", "q/q"},
+	} {
+		if got := string(Parse([]byte(v.src), BASIC)); got != v.want {
+			t.Logf("%q: %q", v.src, got)
+			t.Fail()
+		}
+	}
 }
 
 func TestExample1(t *testing.T) {
@@ -12,97 +12,232 @@ type Block interface {
 	Content() []byte // pure text including inline.
 }
 
-// TODO:support block html
+// BlockHtml represents html blocks,e.g. <div>,<table>,<!-- -->.
 type BlockHtml struct {
+	raw []byte
+	doc *document
+}
+
+// Content returns text of the html without tags.
+func (h BlockHtml) Content() []byte { return h.doc.htmlToText(h.raw) }
+func (h BlockHtml) Type() kind.Kind { return kind.HTMLBlock }
+
+// HTML returns the raw html.
+func (h BlockHtml) HTML() []byte { return h.raw }
+
+// Table represents pipe tables of GFM,
+// the first row is the header.
+type Table struct {
+	align []Align
+	rows  [][][]byte // cells of rows.
+	doc   *document
+}
+
+func (t Table) Type() kind.Kind { return kind.Table }
+
+// Align returns the alignments of the columns.
+func (t Table) Align() []Align { return t.align }
+
+// Header returns the text of the header cells.
+func (t Table) Header() [][]byte { return t.row(0) }
+
+// Rows returns the text of the cells of the body rows.
+func (t Table) Rows() [][][]byte {
+	var rows [][][]byte
+	for i := 1; i < len(t.rows); i++ {
+		rows = append(rows, t.row(i))
+	}
+	return rows
+}
+
+// Spans returns the spans of the cell at column col of row,
+// row 0 is the header.
+func (t Table) Spans(row, col int) []Span {
+	_, spans := t.doc.spans(t.rows[row][col])
+	return spans
+}
+
+// row returns the text of the cells of row i.
+func (t Table) row(i int) [][]byte {
+	var cells [][]byte
+	for _, cell := range t.rows[i] {
+		cells = append(cells, t.doc.spanText(cell))
+	}
+	return cells
+}
+
+// Content returns the rows on lines with the columns padded to the same display width,
+// line breaks in the cells are spaces,or the cells which are not empty on lines with the rows separated by blank lines
+// if the options require one cell per line.
+func (t Table) Content() []byte {
+	rows := [][][]byte{t.Header()}
+	rows = append(rows, t.Rows()...)
+	if t.doc.opts.TableStyle == TableCellPerLine {
+		var out [][]byte
+		for _, cells := range rows {
+			var lines [][]byte
+			for _, cell := range cells {
+				if len(cell) > 0 {
+					lines = append(lines, cell)
+				}
+			}
+			out = append(out, bytes.Join(lines, []byte("\n")))
+		}
+		return bytes.Join(out, []byte("\n\n"))
+	}
+	widths := make([]int, len(t.align))
+	for _, cells := range rows {
+		for i, cell := range cells {
+			// line breaks of <br> and hard breaks would split the row.
+			cell = bytes.ReplaceAll(cell, []byte("\n"), []byte(" "))
+			cells[i] = cell
+			if w := displayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	var out [][]byte
+	for _, cells := range rows {
+		var line [][]byte
+		for i, cell := range cells {
+			line = append(line, pad(cell, widths[i], t.align[i]))
+		}
+		// columns are separated by two spaces.
+		out = append(out, bytes.TrimRight(bytes.Join(line, []byte("  ")), " "))
+	}
+	return bytes.Join(out, []byte("\n"))
 }
 
 // Head represents element beginning with '#'
 type Head struct {
 	level   int // head type h1,h2,...h6
 	content []byte
+	doc     *document
 }
 
-func (h Head) Content() []byte { return h.content }
+// Content returns text of the head,with spans replaced by their contents.
+func (h Head) Content() []byte { return h.doc.spanText(h.content) }
 func (h Head) Type() kind.Kind { return kind.Head }
 
 // Paragraph represents paragraph.
 type Paragraph struct {
 	content []byte
+	doc     *document
 }
 
-func (p Paragraph) Content() []byte {
-	var (
-		sp     = newSpanParser(p.content)
-		spans  []Span
-		length int
-	)
-	for s := sp.element(); s != nil; s = sp.element() {
-		spans = append(spans, s)
-	}
-	p.content = sp.src
-
-	for _, v := range spans {
-		p.content = append(p.content[:length+v.StartPos()], append(v.Content(), p.content[length+v.StartPos():]...)...)
-		length += len(v.Content())
-	}
-	return p.content
-}
+func (p Paragraph) Content() []byte { return p.doc.spanText(p.content) }
 
 func (p Paragraph) Type() kind.Kind { return kind.Paragraph }
 
 // BlockQuote represents element beginning with '>'
 type QuoteBlock struct {
 	level     int // level of recursive layer
-	content   []byte
 	subBlocks []Block
 }
 
 func (q QuoteBlock) Content() []byte {
 	var contents [][]byte
 	for _, v := range q.subBlocks {
-		contents = append(contents, v.Content())
+		// blocks without text,e.g. comments,leave no lines.
+		if c := v.Content(); len(c) > 0 {
+			contents = append(contents, c)
+		}
 	}
 	return bytes.Join(contents, []byte("\n"))
 }
 
 func (q QuoteBlock) Type() kind.Kind { return kind.QuoteBlock }
 
+// Depth returns the number of quotes the quote is nested in,
+// including itself.
+func (q QuoteBlock) Depth() int { return q.level }
+
+// Blocks returns blocks in the quote.
+func (q QuoteBlock) Blocks() []Block { return q.subBlocks }
+
 // List represents element beginning with '*'|'+'|'-'|digit
 type List struct {
-	level int // recursive level
-	items []*Item
+	ordered bool
+	bullet  byte // marker of unorder list or delimiter of order list.
+	start   int  // number of the first item of order list.
+	tight   bool // no blank lines between items or their blocks.
+	items   []*Item
 }
 
+// Ordered reports whether the items are numbered.
+func (l List) Ordered() bool { return l.ordered }
+
+// Bullet returns '*'|'+'|'-' of unorder list,
+// or the delimiter '.'|')' following the number of order list.
+func (l List) Bullet() byte { return l.bullet }
+
+// Start returns the number of the first item of order list.
+func (l List) Start() int { return l.start }
+
+// Tight reports whether no blank lines separate items or their blocks.
+func (l List) Tight() bool { return l.tight }
+
+// Items returns items of the list.
+func (l List) Items() []*Item { return l.items }
+
 // list has no inline but subitems have inline elements.
 func (l List) Type() kind.Kind { return kind.List }
 
-// TODO:handle sub elements
+// Content returns items on consecutive lines,
+// items of loose list are separated by blank lines.
 func (l List) Content() []byte {
+	sep := []byte("\n")
+	if !l.tight {
+		sep = []byte("\n\n")
+	}
 	var output [][]byte
 	for _, v := range l.items {
-		output = append(output, v.content)
-		for _, b := range v.subBlocks {
-			output = append(output, b.Content())
-		}
+		output = append(output, v.Content())
 	}
-	return bytes.Join(output, []byte("\n"))
+	return bytes.Join(output, sep)
 }
 
-// list item.
+// list item,a container of blocks.
 type Item struct {
-	content   []byte
 	subBlocks []Block
 }
 
-// CodeBlock represents element beginning with one tab or at least a 4 spaces.
+// Blocks returns blocks in the item.
+func (i Item) Blocks() []Block { return i.subBlocks }
+
+// Content returns content of blocks in the item.
+func (i Item) Content() []byte {
+	var output [][]byte
+	for _, b := range i.subBlocks {
+		if c := b.Content(); len(c) > 0 {
+			output = append(output, c)
+		}
+	}
+	return bytes.Join(output, []byte("\n"))
+}
+
+// CodeBlock represents element beginning with one tab or at least a 4 spaces,
+// or fenced by '```' or '~~~'.
 type CodeBlock struct {
-	level   int // recursive level
+	line    int // line number the block begins at.
 	content []byte
+	fenced  bool
+	info    []byte // info string following the opening fence.
+	lang    []byte // first word of the info string.
 }
 
 func (c CodeBlock) Content() []byte { return c.content }
 func (c CodeBlock) Type() kind.Kind { return kind.CodeBlock }
 
+// Fenced reports whether the code is fenced rather than indented.
+func (c CodeBlock) Fenced() bool { return c.fenced }
+
+// Info returns the info string of fenced code.
+func (c CodeBlock) Info() string { return string(c.info) }
+
+// Lang returns the language of fenced code,e.g. go for "```go".
+func (c CodeBlock) Lang() string { return string(c.lang) }
+
 // Rule represents horizontal rules
 type Rule struct {
 }
@@ -120,20 +255,40 @@ type Span interface {
 type Emphasis struct {
 	start   int
 	content []byte
+	doc     *document
 }
 
 func (e Emphasis) Type() kind.Kind { return kind.Emphasis }
-func (e Emphasis) Content() []byte { return e.content }
-func (e Emphasis) StartPos() int   { return e.start }
+
+// Content returns text of the emphasis,with nested spans replaced by their contents.
+func (e Emphasis) Content() []byte { return e.doc.spanText(e.content) }
+
+// Spans returns spans nested in the emphasis.
+func (e Emphasis) Spans() []Span {
+	_, spans := e.doc.spans(e.content)
+	return spans
+}
+
+func (e Emphasis) StartPos() int { return e.start }
 
 type Strong struct {
 	start   int
 	content []byte
+	doc     *document
 }
 
 func (s Strong) Type() kind.Kind { return kind.Strong }
-func (s Strong) Content() []byte { return s.content }
-func (s Strong) StartPos() int   { return s.start }
+
+// Content returns text of the strong emphasis,with nested spans replaced by their contents.
+func (s Strong) Content() []byte { return s.doc.spanText(s.content) }
+
+// Spans returns spans nested in the strong emphasis.
+func (s Strong) Spans() []Span {
+	_, spans := s.doc.spans(s.content)
+	return spans
+}
+
+func (s Strong) StartPos() int { return s.start }
 
 type Code struct {
 	start   int
@@ -150,24 +305,178 @@ type Link struct {
 	text  []byte
 	title []byte
 	url   []byte
+	refer bool // url and title are defined by the reference.
+	auto  bool // autolink whose text is the url.
+	doc   *document
 }
 
 func (l Link) Type() kind.Kind { return kind.Link }
-func (l Link) Content() []byte { return bytes.Join([][]byte{l.text, l.title, l.url}, []byte{}) }
 func (l Link) StartPos() int   { return l.start }
 
+// Content returns text of the link,
+// followed by title and url if the options require.
+func (l Link) Content() []byte {
+	// the url of autolinks is the text,which is not repeated,
+	// and the text is not parsed as spans.
+	if l.auto {
+		return l.text
+	}
+	if l.refer {
+		ref := l.doc.resolve(l.id, l.text)
+		if ref == nil {
+			return unresolved(nil, l.doc.spanText(l.text), l.id)
+		}
+		l.url, l.title = ref.link, ref.title
+	}
+	return l.doc.linkText(l.doc.spanText(l.text), l.title, l.url)
+}
+
+// URL returns the destination of the link,
+// which is nil if the reference is not defined.
+func (l Link) URL() []byte {
+	if l.refer {
+		if ref := l.doc.resolve(l.id, l.text); ref != nil {
+			return ref.link
+		}
+		return nil
+	}
+	return l.url
+}
+
 type Image struct {
 	start int
 	id    []byte
 	text  []byte
 	title []byte
 	link  []byte
+	refer bool // link and title are defined by the reference.
+	doc   *document
 }
 
 func (i Image) Type() kind.Kind { return kind.Image }
-func (i Image) Content() []byte { return bytes.Join([][]byte{i.text, i.title, i.link}, []byte{}) }
 func (i Image) StartPos() int   { return i.start }
 
-// TODO: support inline html
+// Content returns alt text of the image,
+// followed by title and link if the options require.
+func (i Image) Content() []byte {
+	if i.refer {
+		ref := i.doc.resolve(i.id, i.text)
+		if ref == nil {
+			return unresolved([]byte{'!'}, i.doc.spanText(i.text), i.id)
+		}
+		i.link, i.title = ref.link, ref.title
+	}
+	text := i.doc.spanText(i.text)
+	if !i.doc.opts.ImageAlt {
+		text = nil
+	}
+	return i.doc.linkText(text, i.title, i.link)
+}
+
+// Strikethrough represents deleted text enclosed in ~~,
+// which is rendered as the options require.
+type Strikethrough struct {
+	start   int
+	delim   []byte
+	content []byte
+	doc     *document
+}
+
+func (s Strikethrough) Type() kind.Kind { return kind.Strikethrough }
+func (s Strikethrough) StartPos() int   { return s.start }
+func (s Strikethrough) Content() []byte {
+	return s.doc.styled(s.doc.opts.Strikethrough, s.delim, s.content)
+}
+
+// Spans returns spans nested in the strikethrough.
+func (s Strikethrough) Spans() []Span {
+	_, spans := s.doc.spans(s.content)
+	return spans
+}
+
+// Highlight represents text enclosed in ==,
+// which is rendered as the options require.
+type Highlight struct {
+	start   int
+	delim   []byte
+	content []byte
+	doc     *document
+}
+
+func (h Highlight) Type() kind.Kind { return kind.Highlight }
+func (h Highlight) StartPos() int   { return h.start }
+func (h Highlight) Content() []byte { return h.doc.styled(h.doc.opts.Highlight, h.delim, h.content) }
+
+// Spans returns spans nested in the highlight.
+func (h Highlight) Spans() []Span {
+	_, spans := h.doc.spans(h.content)
+	return spans
+}
+
+// Superscript represents text enclosed in ^,
+// which is rendered as the options require.
+type Superscript struct {
+	start   int
+	delim   []byte
+	content []byte
+	doc     *document
+}
+
+func (s Superscript) Type() kind.Kind { return kind.Superscript }
+func (s Superscript) StartPos() int   { return s.start }
+func (s Superscript) Content() []byte {
+	return s.doc.styled(s.doc.opts.Superscript, s.delim, s.content)
+}
+
+// Spans returns spans nested in the superscript.
+func (s Superscript) Spans() []Span {
+	_, spans := s.doc.spans(s.content)
+	return spans
+}
+
+// Subscript represents text enclosed in ~,
+// which is rendered as the options require.
+type Subscript struct {
+	start   int
+	delim   []byte
+	content []byte
+	doc     *document
+}
+
+func (s Subscript) Type() kind.Kind { return kind.Subscript }
+func (s Subscript) StartPos() int   { return s.start }
+func (s Subscript) Content() []byte { return s.doc.styled(s.doc.opts.Subscript, s.delim, s.content) }
+
+// Spans returns spans nested in the subscript.
+func (s Subscript) Spans() []Span {
+	_, spans := s.doc.spans(s.content)
+	return spans
+}
+
+// InlineHTML represents raw html in the text,
+// e.g. <kbd>,</a>,<img src="">,<!-- -->.
 type InlineHTML struct {
+	start int
+	raw   []byte
+	text  []byte // text the tag contributes.
 }
+
+func (h InlineHTML) Type() kind.Kind { return kind.InlineHTML }
+func (h InlineHTML) StartPos() int   { return h.start }
+
+// Content returns text the tag contributes,
+// e.g. new line of <br>,alt text of <img>,url of </a> if the options require.
+func (h InlineHTML) Content() []byte { return h.text }
+
+// HTML returns the raw html.
+func (h InlineHTML) HTML() []byte { return h.raw }
+
+// LineBreak represents a hard line break,
+// i.e. a backslash or at least two spaces at the end of a line.
+type LineBreak struct {
+	start int
+}
+
+func (b LineBreak) Type() kind.Kind { return kind.LineBreak }
+func (b LineBreak) StartPos() int   { return b.start }
+func (b LineBreak) Content() []byte { return []byte{'\n'} }
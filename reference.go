@@ -0,0 +1,301 @@
+package md2txt
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+// define records ref of label,
+// the first definition of a label is used.
+func (d *document) define(label string, ref *reference) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.refs == nil {
+		d.refs = make(map[string]*reference)
+	}
+	if _, ok := d.refs[label]; !ok {
+		d.refs[label] = ref
+	}
+}
+
+// lookup returns the reference defined with label,
+// returns nil if there is none.
+func (d *document) lookup(label []byte) *reference {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.refs[normalizeLabel(label)]
+}
+
+// resolve returns the reference of a link,
+// id is the label of full reference,or text for collapsed and shortcut references.
+func (d *document) resolve(id, text []byte) *reference {
+	if len(id) == 0 {
+		id = text
+	}
+	// labels longer than 999 characters are never defined.
+	if len(id) > 999 {
+		return nil
+	}
+	return d.lookup(id)
+}
+
+// unresolved returns the text of a reference link without definition,
+// which is kept as it is written.
+func unresolved(prefix, text, id []byte) []byte {
+	out := append(append(append(prefix, '['), text...), ']')
+	if id != nil {
+		out = append(append(append(out, '['), id...), ']')
+	}
+	return out
+}
+
+// defineRefs records link reference definitions beginning the paragraph content,
+// and returns the rest of the content.
+func (d *document) defineRefs(content []byte) []byte {
+	for {
+		label, ref, n := linkDefinition(content)
+		if n == 0 {
+			return content
+		}
+		d.define(label, ref)
+		content = content[n:]
+	}
+}
+
+// normalizeLabel returns the label to match references,
+// which is case folded and whitespace collapsed.
+func normalizeLabel(label []byte) string {
+	s := strings.Join(strings.Fields(string(label)), " ")
+	return foldCase(s)
+}
+
+// foldCase returns s case folded,
+// characters folding to several characters are replaced by them,e.g. ß and ẞ by ss,
+// others are folded to the lower case of their upper case.
+func foldCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if f, ok := fullFold[r]; ok {
+			b.WriteString(f)
+		} else {
+			b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+		}
+	}
+	return b.String()
+}
+
+// fullFold is the full case folding of CaseFolding.txt of Unicode,
+// the characters which fold to more than one character.
+var fullFold = map[rune]string{
+	0x00DF: "ss", 0x0130: "i\u0307", 0x0149: "\u02BCn", 0x01F0: "j\u030C",
+	0x0390: "\u03B9\u0308\u0301", 0x03B0: "\u03C5\u0308\u0301", 0x0587: "\u0565\u0582",
+	0x1E96: "h\u0331", 0x1E97: "t\u0308", 0x1E98: "w\u030A", 0x1E99: "y\u030A", 0x1E9A: "a\u02BE",
+	0x1E9E: "ss", 0x1F50: "\u03C5\u0313", 0x1F52: "\u03C5\u0313\u0300", 0x1F54: "\u03C5\u0313\u0301",
+	0x1F56: "\u03C5\u0313\u0342", 0x1F80: "\u1F00\u03B9", 0x1F81: "\u1F01\u03B9",
+	0x1F82: "\u1F02\u03B9", 0x1F83: "\u1F03\u03B9", 0x1F84: "\u1F04\u03B9", 0x1F85: "\u1F05\u03B9",
+	0x1F86: "\u1F06\u03B9", 0x1F87: "\u1F07\u03B9", 0x1F88: "\u1F00\u03B9", 0x1F89: "\u1F01\u03B9",
+	0x1F8A: "\u1F02\u03B9", 0x1F8B: "\u1F03\u03B9", 0x1F8C: "\u1F04\u03B9", 0x1F8D: "\u1F05\u03B9",
+	0x1F8E: "\u1F06\u03B9", 0x1F8F: "\u1F07\u03B9", 0x1F90: "\u1F20\u03B9", 0x1F91: "\u1F21\u03B9",
+	0x1F92: "\u1F22\u03B9", 0x1F93: "\u1F23\u03B9", 0x1F94: "\u1F24\u03B9", 0x1F95: "\u1F25\u03B9",
+	0x1F96: "\u1F26\u03B9", 0x1F97: "\u1F27\u03B9", 0x1F98: "\u1F20\u03B9", 0x1F99: "\u1F21\u03B9",
+	0x1F9A: "\u1F22\u03B9", 0x1F9B: "\u1F23\u03B9", 0x1F9C: "\u1F24\u03B9", 0x1F9D: "\u1F25\u03B9",
+	0x1F9E: "\u1F26\u03B9", 0x1F9F: "\u1F27\u03B9", 0x1FA0: "\u1F60\u03B9", 0x1FA1: "\u1F61\u03B9",
+	0x1FA2: "\u1F62\u03B9", 0x1FA3: "\u1F63\u03B9", 0x1FA4: "\u1F64\u03B9", 0x1FA5: "\u1F65\u03B9",
+	0x1FA6: "\u1F66\u03B9", 0x1FA7: "\u1F67\u03B9", 0x1FA8: "\u1F60\u03B9", 0x1FA9: "\u1F61\u03B9",
+	0x1FAA: "\u1F62\u03B9", 0x1FAB: "\u1F63\u03B9", 0x1FAC: "\u1F64\u03B9", 0x1FAD: "\u1F65\u03B9",
+	0x1FAE: "\u1F66\u03B9", 0x1FAF: "\u1F67\u03B9", 0x1FB2: "\u1F70\u03B9", 0x1FB3: "\u03B1\u03B9",
+	0x1FB4: "\u03AC\u03B9", 0x1FB6: "\u03B1\u0342", 0x1FB7: "\u03B1\u0342\u03B9",
+	0x1FBC: "\u03B1\u03B9", 0x1FC2: "\u1F74\u03B9", 0x1FC3: "\u03B7\u03B9", 0x1FC4: "\u03AE\u03B9",
+	0x1FC6: "\u03B7\u0342", 0x1FC7: "\u03B7\u0342\u03B9", 0x1FCC: "\u03B7\u03B9",
+	0x1FD2: "\u03B9\u0308\u0300", 0x1FD3: "\u03B9\u0308\u0301", 0x1FD6: "\u03B9\u0342",
+	0x1FD7: "\u03B9\u0308\u0342", 0x1FE2: "\u03C5\u0308\u0300", 0x1FE3: "\u03C5\u0308\u0301",
+	0x1FE4: "\u03C1\u0313", 0x1FE6: "\u03C5\u0342", 0x1FE7: "\u03C5\u0308\u0342",
+	0x1FF2: "\u1F7C\u03B9", 0x1FF3: "\u03C9\u03B9", 0x1FF4: "\u03CE\u03B9", 0x1FF6: "\u03C9\u0342",
+	0x1FF7: "\u03C9\u0342\u03B9", 0x1FFC: "\u03C9\u03B9", 0xFB00: "ff", 0xFB01: "fi", 0xFB02: "fl",
+	0xFB03: "ffi", 0xFB04: "ffl", 0xFB05: "st", 0xFB06: "st", 0xFB13: "\u0574\u0576",
+	0xFB14: "\u0574\u0565", 0xFB15: "\u0574\u056B", 0xFB16: "\u057E\u0576", 0xFB17: "\u0574\u056D",
+}
+
+// linkDefinition parses a link reference definition beginning src,
+// which is [label]: destination 'title',
+// n is the length of the definition including the line ending,
+// and is 0 if src does not begin with a definition.
+// see https://spec.commonmark.org/0.31.2/#link-reference-definitions
+func linkDefinition(src []byte) (label string, ref *reference, n int) {
+	i := indentation(src)
+	if i > 3 || i >= len(src) || src[i] != '[' {
+		return
+	}
+	// label.
+	j := i + 1
+	for ; j < len(src) && src[j] != ']'; j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			return
+		}
+	}
+	if j+1 >= len(src) || src[j+1] != ':' || j-i-1 > 999 || isBlank(bytes.ReplaceAll(src[i+1:j], []byte{'\n'}, nil)) {
+		return
+	}
+	rawLabel := src[i+1 : j]
+
+	// destination.
+	j = skipSpaces(src, j+2)
+	dest, k, ok := linkDestination(src[j:])
+	if !ok {
+		return
+	}
+	j += k
+	end := lineEnd(src, j)
+
+	// optional title separated by whitespace.
+	var title []byte
+	if t := skipSpaces(src, j); t > j && t < len(src) {
+		if s, k, ok := linkTitle(src[t:]); ok {
+			if e := lineEnd(src, t+k); e != -1 {
+				title, end = s, e
+			}
+		}
+	}
+	if end == -1 {
+		return
+	}
+	return normalizeLabel(rawLabel), &reference{unescape(dest), unescape(title)}, end
+}
+
+// skipSpaces skips spaces,tabs and at most one line ending from src[i:],
+// returns the index of the next character.
+func skipSpaces(src []byte, i int) int {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	if i < len(src) && src[i] == '\n' {
+		i++
+		for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+			i++
+		}
+	}
+	return i
+}
+
+// lineEnd returns the index following the line ending of src[i:],
+// returns -1 if characters other than spaces are before the line ending.
+func lineEnd(src []byte, i int) int {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t') {
+		i++
+	}
+	switch {
+	case i == len(src):
+		return i
+	case src[i] == '\n':
+		return i + 1
+	}
+	return -1
+}
+
+// linkDestination returns the destination beginning src and its length,
+// which is enclosed in '<' and '>',
+// or a run of characters without spaces where parentheses are balanced.
+func linkDestination(src []byte) (dest []byte, n int, ok bool) {
+	if len(src) == 0 {
+		return
+	}
+	if src[0] == '<' {
+		for n = 1; n < len(src); n++ {
+			switch src[n] {
+			case '\\':
+				n++
+			case '\n', '<':
+				return
+			case '>':
+				return src[1:n], n + 1, true
+			}
+		}
+		return
+	}
+	var depth int
+loop:
+	for ; n < len(src); n++ {
+		switch c := src[n]; {
+		case c == '\\' && n+1 < len(src) && isPunct(src[n+1]):
+			n++
+		case c <= ' ' || c == 0x7f:
+			break loop
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		}
+	}
+	if n == 0 || depth != 0 {
+		return
+	}
+	return src[:n], n, true
+}
+
+// linkTitle returns the title beginning src and its length,
+// which is enclosed in double quotes,single quotes or parentheses,
+// and can span lines but not a blank line.
+func linkTitle(src []byte) (title []byte, n int, ok bool) {
+	if len(src) == 0 {
+		return
+	}
+	open, end := src[0], src[0]
+	switch open {
+	case '"', '\'':
+	case '(':
+		end = ')'
+	default:
+		return
+	}
+	for n = 1; n < len(src); n++ {
+		switch c := src[n]; {
+		case c == '\\':
+			n++
+		case c == end:
+			return src[1:n], n + 1, true
+		case c == open:
+			return
+		case c == '\n' && isBlank(lineAt(src, n+1)):
+			return
+		}
+	}
+	return
+}
+
+// isPunct returns true if c is an ascii punctuation character.
+func isPunct(c byte) bool {
+	return c >= '!' && c <= '/' || c >= ':' && c <= '@' || c >= '[' && c <= '`' || c >= '{' && c <= '~'
+}
+
+// unescape removes backslashes escaping punctuation of src,
+// and replaces the entity references by the text they refer to.
+func unescape(src []byte) []byte {
+	if bytes.IndexByte(src, '\\') == -1 && bytes.IndexByte(src, '&') == -1 {
+		return src
+	}
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\\' && i+1 < len(src) && isPunct(src[i+1]):
+			i++
+			out = append(out, src[i])
+		case c == '&':
+			if n, text := entity(src[i:]); n > 0 {
+				out = append(out, text...)
+				i += n - 1
+			} else {
+				out = append(out, c)
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+package md2txt
+
+import (
+	"encoding/json"
+	"flag"
+	"html"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the examples are taken from the CommonMark spec (https://spec.commonmark.org),
+// run "go test -run Spec -v -spec.section=Tabs" to see the failing examples of a section.
+var specSection = flag.String("spec.section", "", "log failing CommonMark examples of the section")
+
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+// specMinimum is the number of examples of each section known to pass,
+// raise it when more examples pass.
+var specMinimum = map[string]int{
+	"Tabs":              11,
+	"Backslash escapes": 13,
+	"Entity and numeric character references": 17,
+	"Precedence":                   1,
+	"Thematic breaks":              19,
+	"ATX headings":                 18,
+	"Setext headings":              26,
+	"Indented code blocks":         12,
+	"Fenced code blocks":           29,
+	"HTML blocks":                  40,
+	"Link reference definitions":   27,
+	"Paragraphs":                   8,
+	"Blank lines":                  1,
+	"Block quotes":                 25,
+	"List items":                   48,
+	"Lists":                        26,
+	"Inlines":                      1,
+	"Code spans":                   22,
+	"Emphasis and strong emphasis": 132,
+	"Links":                        89,
+	"Images":                       22,
+	"Autolinks":                    19,
+	"Raw HTML":                     18,
+	"Hard line breaks":             15,
+	"Soft line breaks":             2,
+	"Textual content":              3,
+}
+
+// TestCommonMarkSpec compares the text of the html expected by the spec
+// with the output of the CommonMark extension,and reports the pass rate of each section,
+// it fails if fewer examples of a section pass than specMinimum records.
+func TestCommonMarkSpec(t *testing.T) {
+	data, err := os.ReadFile("testdata/spec.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var examples []specExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sections []string
+		passed   = make(map[string]int)
+		total    = make(map[string]int)
+		count    int
+	)
+	for _, e := range examples {
+		if total[e.Section] == 0 {
+			sections = append(sections, e.Section)
+		}
+		total[e.Section]++
+		got, ok := specParse(e.Markdown)
+		want := specText(htmlText(e.HTML))
+		if ok && specText(got) == want {
+			passed[e.Section]++
+			count++
+			continue
+		}
+		if *specSection != "" && strings.Contains(e.Section, *specSection) {
+			t.Logf("example %d:\n%q\nwant %q\ngot  %q", e.Example, e.Markdown, want, specText(got))
+		}
+	}
+	for _, s := range sections {
+		t.Logf("%-42s %3d/%3d %5.1f%%", s, passed[s], total[s], 100*float64(passed[s])/float64(total[s]))
+		if passed[s] < specMinimum[s] {
+			t.Errorf("%s: %d examples pass,want at least %d", s, passed[s], specMinimum[s])
+		}
+	}
+	t.Logf("%-42s %3d/%3d %5.1f%%", "total", count, len(examples), 100*float64(count)/float64(len(examples)))
+}
+
+// specParse parses src in CommonMark,ok is false if it does not finish in time.
+func specParse(src string) (out string, ok bool) {
+	done := make(chan string, 1)
+	go func() {
+		done <- string(Parse([]byte(src), CommonMark))
+	}()
+	select {
+	case out = <-done:
+		return out, true
+	case <-time.After(200 * time.Millisecond):
+		return "", false
+	}
+}
+
+// specText trims the trailing spaces of every line,collapses the spaces in it
+// as html is rendered,and drops blank lines,so that only the text is compared.
+func specText(s string) string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimRight(l, " \t")
+		if l == "" {
+			continue
+		}
+		indent := len(l) - len(strings.TrimLeft(l, " "))
+		for strings.Contains(l[indent:], "  ") {
+			l = l[:indent] + strings.ReplaceAll(l[indent:], "  ", " ")
+		}
+		lines = append(lines, l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// block level tags of the html produced by the spec.
+var specBlockTags = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true, "pre": true, "hr": true,
+	"div": true, "table": true, "thead": true, "tbody": true, "tr": true, "th": true, "td": true,
+}
+
+// htmlText returns the text of s,
+// block level tags and <br /> begin new lines,images are replaced by their alt text,
+// comments,declarations and the content of <script>,<style> are dropped.
+func htmlText(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i == -1 {
+			b.WriteString(html.UnescapeString(s))
+			break
+		}
+		b.WriteString(html.UnescapeString(s[:i]))
+		s = s[i:]
+		if strings.HasPrefix(s, "<!--") {
+			j := strings.Index(s, "-->")
+			if j == -1 {
+				break
+			}
+			s = s[j+3:]
+			continue
+		}
+		j := strings.IndexByte(s, '>')
+		if j == -1 {
+			b.WriteString(s)
+			break
+		}
+		tag := s[1:j]
+		s = s[j+1:]
+		name := tag
+		if k := strings.IndexAny(name, " \t\n"); k != -1 {
+			name = name[:k]
+		}
+		name = strings.ToLower(strings.Trim(name, "/"))
+		switch {
+		case name == "script" || name == "style":
+			if !strings.HasPrefix(tag, "/") {
+				k := strings.Index(strings.ToLower(s), "</"+name)
+				if k == -1 {
+					s = ""
+				} else {
+					s = s[k:]
+				}
+			}
+		case name == "br" || specBlockTags[name]:
+			b.WriteByte('\n')
+		case name == "img":
+			if k := strings.Index(tag, `alt="`); k != -1 {
+				alt := tag[k+5:]
+				b.WriteString(html.UnescapeString(alt[:strings.IndexByte(alt, '"')]))
+			}
+		}
+	}
+	return b.String()
+}
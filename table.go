@@ -0,0 +1,189 @@
+package md2txt
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Align is the alignment of a table column.
+type Align int
+
+const (
+	AlignNone   Align = iota // no colon in the delimiter row.
+	AlignLeft                // :---
+	AlignCenter              // :---:
+	AlignRight               // ---:
+)
+
+// tableStart returns the alignments of the columns,
+// if line is the header row and next is the delimiter row of a table,
+// the rows must have the same number of cells,and one of them contains '|'.
+// see https://github.github.com/gfm/#tables-extension-
+func tableStart(line, next []byte) (align []Align, ok bool) {
+	if indentation(line) > 3 || indentation(next) > 3 {
+		return nil, false
+	}
+	if bytes.IndexByte(line, '|') == -1 && bytes.IndexByte(next, '|') == -1 {
+		return nil, false
+	}
+	delims := splitRow(next)
+	for _, cell := range delims {
+		a, ok := delimiterCell(cell)
+		if !ok {
+			return nil, false
+		}
+		align = append(align, a)
+	}
+	if len(align) == 0 || len(splitRow(line)) != len(align) {
+		return nil, false
+	}
+	return align, true
+}
+
+// isTable returns true if a table begins at the current line,
+// which requires the table extension.
+func (p *blockParser) isTable() bool {
+	line := p.line()
+	next := p.cur + len(line) + 1
+	if !p.doc.has(ExtTable) || next > len(p.src) {
+		return false
+	}
+	_, ok := tableStart(line, lineAt(p.src, next))
+	return ok
+}
+
+// delimiterCell returns the alignment of a cell of the delimiter row,
+// which is hyphens with optional leading and trailing colons.
+func delimiterCell(cell []byte) (Align, bool) {
+	left, right := bytes.HasPrefix(cell, []byte{':'}), bytes.HasSuffix(cell, []byte{':'})
+	dashes := bytes.TrimSuffix(bytes.TrimPrefix(cell, []byte{':'}), []byte{':'})
+	if len(dashes) == 0 || len(bytes.Trim(dashes, "-")) != 0 {
+		return AlignNone, false
+	}
+	switch {
+	case left && right:
+		return AlignCenter, true
+	case left:
+		return AlignLeft, true
+	case right:
+		return AlignRight, true
+	}
+	return AlignNone, true
+}
+
+// splitRow returns the trimmed cells of a table row,
+// which are separated by '|' not escaped,
+// the leading and trailing '|' are optional.
+// \| is kept as '|' in the cells,even in code spans.
+func splitRow(line []byte) [][]byte {
+	line = bytes.TrimSpace(line)
+	line = bytes.TrimPrefix(line, []byte{'|'})
+	if n := len(line); n > 0 && line[n-1] == '|' && (n == 1 || line[n-2] != '\\') {
+		line = line[:n-1]
+	}
+	var (
+		cells [][]byte
+		cell  []byte
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell = append(cell, '|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, bytes.TrimSpace(cell))
+			cell = nil
+		default:
+			cell = append(cell, line[i])
+		}
+	}
+	return append(cells, bytes.TrimSpace(cell))
+}
+
+// parseTable parses the header row,the delimiter row and the body rows of a table,
+// which ends at a blank line or a line beginning other blocks.
+// body rows are cut or filled with empty cells to the number of columns.
+func parseTable(p *blockParser) stateFn {
+	header := p.line()
+	p.skipLine()
+	align, _ := tableStart(header, p.line())
+	p.skipLine()
+	table := &Table{align: align, rows: [][][]byte{splitRow(header)}, doc: p.doc}
+	for p.cur < len(p.src) {
+		line := p.line()
+		if isBlank(line) || p.interrupt(line) {
+			break
+		}
+		cells := make([][]byte, len(align))
+		copy(cells, splitRow(line))
+		table.rows = append(table.rows, cells)
+		p.skipLine()
+	}
+	p.emit(table)
+	return parseBegin
+}
+
+// wide is the ranges of east asian wide and fullwidth characters,
+// which take two columns.
+var wide = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x1100, 0x115f, 1},
+		{0x231a, 0x231b, 1},
+		{0x2329, 0x232a, 1},
+		{0x2e80, 0x303e, 1},
+		{0x3041, 0x33ff, 1},
+		{0x3400, 0x4dbf, 1},
+		{0x4e00, 0x9fff, 1},
+		{0xa000, 0xa4cf, 1},
+		{0xa960, 0xa97f, 1},
+		{0xac00, 0xd7a3, 1},
+		{0xf900, 0xfaff, 1},
+		{0xfe10, 0xfe19, 1},
+		{0xfe30, 0xfe6f, 1},
+		{0xff00, 0xff60, 1},
+		{0xffe0, 0xffe6, 1},
+	},
+	R32: []unicode.Range32{
+		{0x1f300, 0x1f64f, 1},
+		{0x1f900, 0x1f9ff, 1},
+		{0x20000, 0x2fffd, 1},
+		{0x30000, 0x3fffd, 1},
+	},
+}
+
+// displayWidth returns the number of columns text takes in a terminal,
+// wide characters take two columns and combining marks take none.
+func displayWidth(text []byte) int {
+	var n int
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		switch {
+		case unicode.Is(wide, r):
+			n += 2
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// pad returns text padded with spaces to width as align requires,
+// text is on the left unless aligned to the right or center.
+func pad(text []byte, width int, align Align) []byte {
+	n := width - displayWidth(text)
+	if n <= 0 {
+		return text
+	}
+	var left int
+	switch align {
+	case AlignRight:
+		left = n
+	case AlignCenter:
+		left = n / 2
+	}
+	out := append(bytes.Repeat([]byte{' '}, left), text...)
+	return append(out, bytes.Repeat([]byte{' '}, n-left)...)
+}